@@ -1 +1,3 @@
 module github.com/ShevaXu/golang
+
+go 1.13
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy computes the sleep between tries.
+// Strategies are used by a client concurrently
+// and should be safe for it.
+// See https://www.awsarchitectureblog.com/2015/03/backoff.html
+// for the jittered ones.
+type BackoffStrategy interface {
+	// Sleep returns the sleep before the retry-th retry
+	// (starting from 1), given the previous sleep
+	// (0 for the first retry).
+	Sleep(retry int, previous time.Duration) time.Duration
+
+	// Cap returns the max sleep, which caps Retry-After as well.
+	Cap() time.Duration
+}
+
+// RandSource provides random numbers for jittered backoff,
+// e.g., a *rand.Rand for deterministic tests; it should be safe
+// for concurrent use if shared (see NewLockedRand).
+// The global source of math/rand is used if nil.
+type RandSource interface {
+	Int63n(n int64) int64
+}
+
+// lockedRand guards a rand.Rand with a mutex.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+// NewLockedRand returns a RandSource seeded with seed
+// which is safe for concurrent use.
+func NewLockedRand(seed int64) RandSource {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+// randBetween returns a random duration in [lo, hi].
+func randBetween(src RandSource, lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	n := int64(hi-lo) + 1
+	if src == nil {
+		return lo + time.Duration(rand.Int63n(n))
+	}
+	return lo + time.Duration(src.Int63n(n))
+}
+
+// exponential returns min(limit, base * 2^(retry-1)).
+func exponential(base, limit time.Duration, retry int) time.Duration {
+	d := base
+	for i := 1; i < retry; i++ {
+		if d >= limit/2 {
+			return limit
+		}
+		d *= 2
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+// ConstantBackoff sleeps the same Delay every time.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// Sleep implements BackoffStrategy.
+func (b ConstantBackoff) Sleep(retry int, previous time.Duration) time.Duration {
+	return b.Delay
+}
+
+// Cap implements BackoffStrategy.
+func (b ConstantBackoff) Cap() time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff doubles the sleep every time without jitter:
+// sleep = min(cap, base * 2^(retry-1)).
+type ExponentialBackoff struct {
+	Base, Max time.Duration
+}
+
+// Sleep implements BackoffStrategy.
+func (b ExponentialBackoff) Sleep(retry int, previous time.Duration) time.Duration {
+	return exponential(b.Base, b.Max, retry)
+}
+
+// Cap implements BackoffStrategy.
+func (b ExponentialBackoff) Cap() time.Duration {
+	return b.Max
+}
+
+// FullJitter is the exponential backoff with full jitter:
+// sleep = random_between(0, min(cap, base * 2^(retry-1))).
+type FullJitter struct {
+	Base, Max time.Duration
+	Rand      RandSource
+}
+
+// Sleep implements BackoffStrategy.
+func (b FullJitter) Sleep(retry int, previous time.Duration) time.Duration {
+	return randBetween(b.Rand, 0, exponential(b.Base, b.Max, retry))
+}
+
+// Cap implements BackoffStrategy.
+func (b FullJitter) Cap() time.Duration {
+	return b.Max
+}
+
+// EqualJitter is the exponential backoff with half of it jittered:
+// temp = min(cap, base * 2^(retry-1));
+// sleep = temp/2 + random_between(0, temp/2).
+type EqualJitter struct {
+	Base, Max time.Duration
+	Rand      RandSource
+}
+
+// Sleep implements BackoffStrategy.
+func (b EqualJitter) Sleep(retry int, previous time.Duration) time.Duration {
+	half := exponential(b.Base, b.Max, retry) / 2
+	return half + randBetween(b.Rand, 0, half)
+}
+
+// Cap implements BackoffStrategy.
+func (b EqualJitter) Cap() time.Duration {
+	return b.Max
+}
+
+// DecorrelatedJitter is Backoff in time.Duration:
+// sleep = min(cap, random_between(base, sleep * 3)).
+type DecorrelatedJitter struct {
+	Base, Max time.Duration
+	Rand      RandSource
+}
+
+// Sleep implements BackoffStrategy.
+func (b DecorrelatedJitter) Sleep(retry int, previous time.Duration) time.Duration {
+	sleep := randBetween(b.Rand, b.Base, previous*3)
+	if sleep > b.Max {
+		return b.Max
+	}
+	return sleep
+}
+
+// Cap implements BackoffStrategy.
+func (b DecorrelatedJitter) Cap() time.Duration {
+	return b.Max
+}
+
+// FibonacciBackoff grows the sleep by the Fibonacci sequence:
+// sleep = min(cap, base * fib(retry)), i.e., base, base, 2*base...
+type FibonacciBackoff struct {
+	Base, Max time.Duration
+}
+
+// Sleep implements BackoffStrategy.
+func (b FibonacciBackoff) Sleep(retry int, previous time.Duration) time.Duration {
+	prev, cur := time.Duration(0), b.Base
+	for i := 1; i < retry; i++ {
+		if cur >= b.Max {
+			return b.Max
+		}
+		prev, cur = cur, prev+cur
+	}
+	if cur > b.Max {
+		return b.Max
+	}
+	return cur
+}
+
+// Cap implements BackoffStrategy.
+func (b FibonacciBackoff) Cap() time.Duration {
+	return b.Max
+}
@@ -0,0 +1,87 @@
+package web_test
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+// sleeps returns the first n sleeps of the strategy.
+func sleeps(b web.BackoffStrategy, n int) []time.Duration {
+	var list []time.Duration
+	var prev time.Duration
+	for i := 1; i <= n; i++ {
+		prev = b.Sleep(i, prev)
+		list = append(list, prev)
+	}
+	return list
+}
+
+func TestBackoffStrategies(t *testing.T) {
+	a := assert.New(t)
+
+	const ms = time.Millisecond
+
+	a.Equal([]time.Duration{5 * ms, 5 * ms, 5 * ms},
+		sleeps(web.ConstantBackoff{Delay: 5 * ms}, 3), "Constant")
+	a.Equal([]time.Duration{10 * ms, 20 * ms, 40 * ms, 50 * ms, 50 * ms},
+		sleeps(web.ExponentialBackoff{Base: 10 * ms, Max: 50 * ms}, 5), "Exponential")
+	a.Equal([]time.Duration{10 * ms, 10 * ms, 20 * ms, 30 * ms, 50 * ms, 60 * ms},
+		sleeps(web.FibonacciBackoff{Base: 10 * ms, Max: 60 * ms}, 6), "Fibonacci")
+
+	for i, d := range sleeps(web.FullJitter{Base: 10 * ms, Max: 50 * ms}, 5) {
+		a.True(d >= 0 && d <= 50*ms, "Full jitter bounded")
+		a.True(d <= 10*ms<<uint(i), "Full jitter under exponential")
+	}
+	for i, d := range sleeps(web.EqualJitter{Base: 10 * ms, Max: 50 * ms}, 5) {
+		exp := web.ExponentialBackoff{Base: 10 * ms, Max: 50 * ms}.Sleep(i+1, 0)
+		a.True(d >= exp/2 && d <= exp, "Equal jitter keeps half")
+	}
+	for _, d := range sleeps(web.DecorrelatedJitter{Base: 10 * ms, Max: 50 * ms}, 5) {
+		a.True(d >= 10*ms && d <= 50*ms, "Decorrelated jitter bounded")
+	}
+	for _, d := range sleeps(web.Backoff{BaseSleep: 10, MaxSleep: 50}, 5) {
+		a.True(d >= 10*ms && d <= 50*ms, "Backoff bounded")
+	}
+	a.Equal(50*ms, web.Backoff{BaseSleep: 10, MaxSleep: 50}.Cap(), "Backoff cap")
+}
+
+func TestBackoffStrategies_Rand(t *testing.T) {
+	a := assert.New(t)
+
+	const ms = time.Millisecond
+
+	for _, newStrategy := range []func(src web.RandSource) web.BackoffStrategy{
+		func(src web.RandSource) web.BackoffStrategy {
+			return web.FullJitter{Base: 10 * ms, Max: time.Second, Rand: src}
+		},
+		func(src web.RandSource) web.BackoffStrategy {
+			return web.EqualJitter{Base: 10 * ms, Max: time.Second, Rand: src}
+		},
+		func(src web.RandSource) web.BackoffStrategy {
+			return web.DecorrelatedJitter{Base: 10 * ms, Max: time.Second, Rand: src}
+		},
+	} {
+		s1 := sleeps(newStrategy(rand.New(rand.NewSource(42))), 5)
+		s2 := sleeps(newStrategy(web.NewLockedRand(42)), 5)
+		a.Equal(s1, s2, "Deterministic with the same seed")
+	}
+}
+
+func TestClientDo_BackoffStrategy(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithBackoff(web.ConstantBackoff{Delay: 20 * time.Millisecond}))
+	start := time.Now()
+	n, _, _, _ := cl.Do(newGet(t, server.URL), 3)
+	a.Equal(3, n, "All tries made")
+	a.True(time.Since(start) >= 40*time.Millisecond, "Slept between tries")
+}
@@ -0,0 +1,218 @@
+package web
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// BalanceStrategy picks the endpoint of a try.
+type BalanceStrategy int
+
+// Strategies of a Balancer.
+const (
+	RoundRobin BalanceStrategy = iota
+	Random
+	LeastOutstanding // the fewest tries in flight
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round-robin"
+	case Random:
+		return "random"
+	case LeastOutstanding:
+		return "least-outstanding"
+	default:
+		return "unknown"
+	}
+}
+
+// Balancer spreads the tries of a client over endpoints
+// serving the same resources, like Mirrors but with each try
+// picked by the Strategy (safe for concurrent use by multiple
+// goroutines). An endpoint failing MaxFailures tries in a row
+// is ejected; after ProbeInterval, a single try probes it and
+// brings it back if it succeeds, like a half-open Breaker.
+// If all are ejected, all are used.
+type Balancer struct {
+	Strategy BalanceStrategy
+	// MaxFailures in a row to eject an endpoint,
+	// never ejected if <= 0.
+	MaxFailures   int
+	ProbeInterval time.Duration
+	// Rand picks for Random,
+	// the global source of math/rand if nil.
+	Rand RandSource
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int // for RoundRobin and ties
+}
+
+type endpoint struct {
+	base        *url.URL
+	outstanding int // tries in flight
+	failures    int // in a row
+	ejected     time.Time
+	probing     bool
+}
+
+// NewBalancer returns a Balancer over the base URLs
+// ejecting after 5 failures and probing every 10s.
+func NewBalancer(strategy BalanceStrategy, urls ...string) (*Balancer, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("web: no endpoint")
+	}
+	b := &Balancer{
+		Strategy:      strategy,
+		MaxFailures:   5,
+		ProbeInterval: 10 * time.Second,
+	}
+	for _, s := range urls {
+		u, err := parseBase(s)
+		if err != nil {
+			return nil, err
+		}
+		b.endpoints = append(b.endpoints, &endpoint{base: u})
+	}
+	return b, nil
+}
+
+// Ejected returns the base URLs ejected.
+func (b *Balancer) Ejected() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var urls []string
+	for _, ep := range b.endpoints {
+		if !ep.ejected.IsZero() {
+			urls = append(urls, ep.base.String())
+		}
+	}
+	return urls
+}
+
+// Outstanding returns the tries in flight to the base URL.
+func (b *Balancer) Outstanding(base string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ep := range b.endpoints {
+		if ep.base.String() == base {
+			return ep.outstanding
+		}
+	}
+	return 0
+}
+
+// pick takes an endpoint for a try, to be released,
+// telling if the try probes an ejected one.
+func (b *Balancer) pick() (*endpoint, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	var healthy []*endpoint
+	for _, ep := range b.endpoints {
+		if ep.ejected.IsZero() {
+			healthy = append(healthy, ep)
+		} else if !ep.probing && now.Sub(ep.ejected) >= b.ProbeInterval {
+			// probe it first
+			ep.probing = true
+			ep.outstanding++
+			return ep, true
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = b.endpoints
+	}
+
+	var ep *endpoint
+	switch b.Strategy {
+	case Random:
+		n := int64(len(healthy))
+		if b.Rand != nil {
+			ep = healthy[b.Rand.Int63n(n)]
+		} else {
+			ep = healthy[rand.Int63n(n)]
+		}
+	case LeastOutstanding:
+		// ties in turn
+		start := b.next % len(healthy)
+		b.next++
+		for i := range healthy {
+			e := healthy[(start+i)%len(healthy)]
+			if ep == nil || e.outstanding < ep.outstanding {
+				ep = e
+			}
+		}
+	default:
+		ep = healthy[b.next%len(healthy)]
+		b.next++
+	}
+	ep.outstanding++
+	return ep, false
+}
+
+// route returns req for the endpoint picked,
+// telling if the try is a probe.
+func (b *Balancer) route(req *http.Request) (*http.Request, *endpoint, bool) {
+	ep, probe := b.pick()
+	r := req.Clone(req.Context())
+	r.URL = rebase(req.URL, ep.base)
+	r.Host = ""
+	return r, ep, probe
+}
+
+// record counts the outcome of a try to ep, ejecting it,
+// or bringing it back only if the try is a probe.
+func (b *Balancer) record(ep *endpoint, probe, failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		ep.probing = false
+		if failure {
+			ep.failures++
+			ep.ejected = time.Now()
+		} else {
+			ep.failures = 0
+			ep.ejected = time.Time{}
+		}
+		return
+	}
+	if !ep.ejected.IsZero() {
+		// left to the probe
+		return
+	}
+	if !failure {
+		ep.failures = 0
+		return
+	}
+	ep.failures++
+	if b.MaxFailures > 0 && ep.failures >= b.MaxFailures {
+		ep.ejected = time.Now()
+	}
+}
+
+// cancel ends a try to ep without outcome.
+func (b *Balancer) cancel(ep *endpoint, probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	ep.probing = false
+	b.mu.Unlock()
+}
+
+// release ends a try to ep in flight.
+func (b *Balancer) release(ep *endpoint) {
+	b.mu.Lock()
+	ep.outstanding--
+	b.mu.Unlock()
+}
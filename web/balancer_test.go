@@ -0,0 +1,196 @@
+package web_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+// CountHandler counts the requests, failing with 503 if down.
+func CountHandler(count *int32, down *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		if down != nil && atomic.LoadInt32(down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(okResp)
+	}
+}
+
+func TestBalanceStrategy_String(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("round-robin", web.RoundRobin.String(), "RoundRobin")
+	a.Equal("random", web.Random.String(), "Random")
+	a.Equal("least-outstanding", web.LeastOutstanding.String(), "LeastOutstanding")
+}
+
+func TestClientDo_Balancer(t *testing.T) {
+	a := assert.New(t)
+
+	counts := make([]int32, 3)
+	var urls []string
+	for i := range counts {
+		s := httptest.NewServer(CountHandler(&counts[i], nil))
+		defer s.Close()
+		urls = append(urls, s.URL)
+	}
+
+	b, err := web.NewBalancer(web.RoundRobin, urls...)
+	a.NoError(err, "Balancer")
+	cl := web.NewClient(web.WithBalancer(b))
+	for i := 0; i < 6; i++ {
+		_, status, _, _ := cl.Do(newGet(t, "http://service/"), 1)
+		a.Equal(http.StatusOK, status, "Served")
+	}
+	for i := range counts {
+		a.Equal(int32(2), atomic.LoadInt32(&counts[i]), "Round-robin")
+	}
+
+	b, _ = web.NewBalancer(web.Random, urls...)
+	b.Rand = web.NewLockedRand(1)
+	cl = web.NewClient(web.WithBalancer(b))
+	for i := 0; i < 60; i++ {
+		cl.Do(newGet(t, "http://service/"), 1)
+	}
+	for i := range counts {
+		a.True(atomic.LoadInt32(&counts[i]) > 2, "Random")
+	}
+
+	_, err = web.NewBalancer(web.RoundRobin)
+	a.True(err != nil, "No endpoint")
+}
+
+func TestClientDo_LeastOutstanding(t *testing.T) {
+	a := assert.New(t)
+
+	var busy, idle int32
+	busyS := httptest.NewServer(CountHandler(&busy, nil))
+	defer busyS.Close()
+	idleS := httptest.NewServer(CountHandler(&idle, nil))
+	defer idleS.Close()
+
+	b, _ := web.NewBalancer(web.LeastOutstanding, busyS.URL, idleS.URL)
+	cl := web.NewClient(web.WithBalancer(b))
+
+	// in flight until closed
+	_, resp, err := cl.Stream(newGet(t, "http://service/"), 1)
+	a.NoError(err, "Streams")
+	a.Equal(1, b.Outstanding(busyS.URL), "Body open")
+
+	for i := 0; i < 3; i++ {
+		cl.Do(newGet(t, "http://service/"), 1)
+	}
+	a.Equal(int32(1), atomic.LoadInt32(&busy), "Busy one spared")
+	a.Equal(int32(3), atomic.LoadInt32(&idle), "Idle one picked")
+
+	resp.Body.Close()
+	a.Equal(0, b.Outstanding(busyS.URL), "Released")
+}
+
+func TestClientDo_BalancerEjection(t *testing.T) {
+	a := assert.New(t)
+
+	var badCount, goodCount int32
+	down := int32(1)
+	bad := httptest.NewServer(CountHandler(&badCount, &down))
+	defer bad.Close()
+	good := httptest.NewServer(CountHandler(&goodCount, nil))
+	defer good.Close()
+
+	b, _ := web.NewBalancer(web.RoundRobin, bad.URL, good.URL)
+	b.MaxFailures = 2
+	b.ProbeInterval = 50 * time.Millisecond
+	cl := web.NewClient(web.WithBalancer(b), web.WithBackoff(web.ConstantBackoff{}))
+
+	// a retry goes to the next endpoint
+	tries, status, _, _ := cl.Do(newGet(t, "http://service/"), 2)
+	a.Equal(2, tries, "Retried")
+	a.Equal(http.StatusOK, status, "On the good one")
+
+	cl.Do(newGet(t, "http://service/"), 1)
+	a.Equal([]string{bad.URL}, b.Ejected(), "Ejected after 2 failures")
+	for i := 0; i < 4; i++ {
+		_, status, _, _ = cl.Do(newGet(t, "http://service/"), 1)
+		a.Equal(http.StatusOK, status, "Ejected one skipped")
+	}
+	a.Equal(int32(2), atomic.LoadInt32(&badCount), "Not hit while ejected")
+
+	// a failed probe keeps it out
+	time.Sleep(60 * time.Millisecond)
+	cl.Do(newGet(t, "http://service/"), 1)
+	a.Equal(int32(3), atomic.LoadInt32(&badCount), "Probed")
+	a.Equal(1, len(b.Ejected()), "Still ejected")
+
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	_, status, _, _ = cl.Do(newGet(t, "http://service/"), 1)
+	a.Equal(http.StatusOK, status, "Probe succeeds")
+	a.Equal(0, len(b.Ejected()), "Brought back")
+}
+
+func TestClientDo_BalancerProbeOnly(t *testing.T) {
+	a := assert.New(t)
+
+	down := int32(1)
+	arrived, release := make(chan struct{}, 2), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Block") != "" {
+			arrived <- struct{}{}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write(okResp)
+	}))
+	defer server.Close()
+
+	b, _ := web.NewBalancer(web.RoundRobin, server.URL)
+	b.MaxFailures = 1
+	b.ProbeInterval = 50 * time.Millisecond
+	cl := web.NewClient(web.WithBalancer(b))
+	blocked := func(ctx context.Context) (int, error) {
+		req := newGet(t, "http://service/")
+		req.Header.Set("X-Block", "1")
+		_, status, _, err := cl.Do(req.WithContext(ctx), 1)
+		return status, err
+	}
+
+	cl.Do(newGet(t, "http://service/"), 1)
+	a.Equal(1, len(b.Ejected()), "Ejected")
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+
+	// the probe is in flight
+	done := make(chan int)
+	go func() {
+		status, _ := blocked(context.Background())
+		done <- status
+	}()
+	<-arrived
+
+	// all ejected, so used, but none is the probe
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := blocked(ctx)
+	a.True(err != nil, "Cancelled")
+	_, status, _, _ := cl.Do(newGet(t, "http://service/"), 1)
+	a.Equal(http.StatusOK, status, "Got 200")
+	a.Equal(1, len(b.Ejected()), "Only the probe brings it back")
+
+	close(release)
+	a.Equal(http.StatusOK, <-done, "Probe succeeds")
+	a.Equal(0, len(b.Ejected()), "Brought back")
+}
@@ -0,0 +1,185 @@
+package web
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is reported when the circuit breaker
+// for the request host is open and the request fails fast.
+var ErrCircuitOpen = errors.New("web: circuit breaker open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// Circuit breaker states.
+const (
+	StateClosed   BreakerState = iota // requests go through
+	StateOpen                         // requests fail fast
+	StateHalfOpen                     // one probe request goes through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker implements the circuit breaker pattern keyed by host
+// (safe for concurrent use by multiple goroutines).
+// A host's breaker opens when failures take FailureRatio
+// of at least MinRequests requests within Window,
+// stays open for CoolDown, then lets one probe through
+// (half-open): closes if it succeeds or opens again otherwise.
+// Failures are errors and ShouldRetry status codes.
+type Breaker struct {
+	// FailureRatio <= 0 opens on any failure.
+	FailureRatio float64
+	// MinRequests <= 0 counts from the first request.
+	MinRequests int
+	// Window <= 0 never resets the counts.
+	Window   time.Duration
+	CoolDown time.Duration
+	// OnStateChange, if not nil, is called on every state change.
+	OnStateChange func(host string, from, to BreakerState)
+
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+// hostBreaker holds the breaker state of a single host.
+type hostBreaker struct {
+	state       BreakerState
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool // a half-open probe is in flight
+}
+
+// NewBreaker returns a Breaker with the given failure ratio
+// and cool-down, counting at least 5 requests in 1m windows.
+func NewBreaker(failureRatio float64, coolDown time.Duration) *Breaker {
+	return &Breaker{
+		FailureRatio: failureRatio,
+		MinRequests:  5,
+		Window:       time.Minute,
+		CoolDown:     coolDown,
+	}
+}
+
+// State returns the current state of the host's breaker.
+func (b *Breaker) State(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h, ok := b.hosts[host]; ok {
+		return h.state
+	}
+	return StateClosed
+}
+
+// get returns the host's breaker, creating one if missing;
+// the lock must be held.
+func (b *Breaker) get(host string, now time.Time) *hostBreaker {
+	if b.hosts == nil {
+		b.hosts = make(map[string]*hostBreaker)
+	}
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostBreaker{windowStart: now}
+		b.hosts[host] = h
+	}
+	return h
+}
+
+// allow reports whether a request to host may go through;
+// each allowed request must be followed by record or release.
+func (b *Breaker) allow(host string) bool {
+	b.mu.Lock()
+	now := time.Now()
+	h := b.get(host, now)
+	from := h.state
+	ok := true
+	switch h.state {
+	case StateOpen:
+		if now.Sub(h.openedAt) < b.CoolDown {
+			ok = false
+			break
+		}
+		h.state = StateHalfOpen
+		h.probing = true
+	case StateHalfOpen:
+		if h.probing {
+			ok = false
+		} else {
+			h.probing = true
+		}
+	}
+	to := h.state
+	b.mu.Unlock()
+
+	b.notify(host, from, to)
+	return ok
+}
+
+// record counts the outcome of an allowed request.
+func (b *Breaker) record(host string, failure bool) {
+	b.mu.Lock()
+	now := time.Now()
+	h := b.get(host, now)
+	from := h.state
+	switch h.state {
+	case StateClosed:
+		if b.Window > 0 && now.Sub(h.windowStart) >= b.Window {
+			h.requests, h.failures = 0, 0
+			h.windowStart = now
+		}
+		h.requests++
+		if failure {
+			h.failures++
+		}
+		// only a failure opens it
+		if failure && h.requests >= b.MinRequests &&
+			float64(h.failures) >= b.FailureRatio*float64(h.requests) {
+			h.state = StateOpen
+			h.openedAt = now
+		}
+	case StateHalfOpen:
+		h.probing = false
+		if failure {
+			h.state = StateOpen
+			h.openedAt = now
+		} else {
+			h.state = StateClosed
+			h.requests, h.failures = 0, 0
+			h.windowStart = now
+		}
+	}
+	to := h.state
+	b.mu.Unlock()
+
+	b.notify(host, from, to)
+}
+
+// release gives up an allowed request without an outcome,
+// e.g., it is cancelled by the caller.
+func (b *Breaker) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h, ok := b.hosts[host]; ok && h.state == StateHalfOpen {
+		h.probing = false
+	}
+}
+
+func (b *Breaker) notify(host string, from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
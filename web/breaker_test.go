@@ -0,0 +1,133 @@
+package web_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestBreakerState_String(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("closed", web.StateClosed.String(), "Closed")
+	a.Equal("open", web.StateOpen.String(), "Open")
+	a.Equal("half-open", web.StateHalfOpen.String(), "Half-open")
+}
+
+func TestClientDo_Breaker(t *testing.T) {
+	a := assert.New(t)
+
+	var down int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	host := u.Host
+
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	b := web.NewBreaker(0.5, 50*time.Millisecond)
+	b.MinRequests = 2
+	b.OnStateChange = func(h string, from, to web.BreakerState) {
+		mu.Lock()
+		defer mu.Unlock()
+		a.Equal(host, h, "Keyed by host")
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+	cl := web.NewClient(web.WithBreaker(b), web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}))
+
+	n, status, _, err := cl.Do(newGet(t, server.URL), 5)
+	a.Equal(2, n, "Open after 2 failures")
+	a.Equal(http.StatusInternalServerError, status, "Status of the last try")
+	a.True(errors.Is(err, web.ErrCircuitOpen), "Fail fast")
+	a.Equal(web.StateOpen, b.State(host), "Breaker open")
+
+	n, _, _, err = cl.Do(newGet(t, server.URL), 5)
+	a.Equal(0, n, "No request while open")
+	a.True(errors.Is(err, web.ErrCircuitOpen), "Fail fast again")
+
+	// still down after cool-down
+	time.Sleep(60 * time.Millisecond)
+	n, _, _, err = cl.Do(newGet(t, server.URL), 5)
+	a.Equal(1, n, "One probe while half-open")
+	a.True(errors.Is(err, web.ErrCircuitOpen), "Probe failed")
+
+	// recovered
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(60 * time.Millisecond)
+	n, status, _, err = cl.Do(newGet(t, server.URL), 5)
+	a.NoError(err, "Probe succeeds")
+	a.Equal(1, n, "One try")
+	a.Equal(http.StatusOK, status, "Got 200")
+	a.Equal(web.StateClosed, b.State(host), "Breaker closed")
+
+	mu.Lock()
+	a.Equal([]string{
+		"closed->open",
+		"open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}, changes, "State changes reported")
+	mu.Unlock()
+}
+
+func TestClientDo_BreakerFailureRules(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusOK, okResp))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	// a body too large is not the host's failure
+	b := web.NewBreaker(0.5, time.Minute)
+	cl := web.NewClient(web.WithBreaker(b), web.WithMaxBodySize(1))
+	for i := 0; i < 10; i++ {
+		_, _, _, err := cl.Do(newGet(t, server.URL), 1)
+		a.True(errors.Is(err, web.ErrBodyTooLarge), "Body too large")
+	}
+	a.Equal(web.StateClosed, b.State(u.Host), "Still closed")
+
+	// only timeouts count with TimeoutOnly
+	refused := httptest.NewServer(okHandler)
+	refusedURL := refused.URL
+	refused.Close()
+	ru, _ := url.Parse(refusedURL)
+	b = web.NewBreaker(0.5, time.Minute)
+	cl = web.NewClient(web.WithBreaker(b), web.TimeoutOnly())
+	for i := 0; i < 10; i++ {
+		cl.Do(newGet(t, refusedURL), 1)
+	}
+	a.Equal(web.StateClosed, b.State(ru.Host), "Refused is not a timeout")
+}
+
+func TestClientDo_BreakerZeroValue(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusOK, okResp))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	for _, b := range []*web.Breaker{{}, web.NewBreaker(0, time.Minute)} {
+		cl := web.NewClient(web.WithBreaker(b))
+		for i := 0; i < 10; i++ {
+			_, status, _, err := cl.Do(newGet(t, server.URL), 1)
+			a.NoError(err, "Not open")
+			a.Equal(http.StatusOK, status, "Got 200")
+		}
+		a.Equal(web.StateClosed, b.State(u.Host), "Successes never open it")
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetryBudget is reported when the client's
+// retry budget is spent and the request fails fast.
+var ErrRetryBudget = errors.New("web: retry budget exhausted")
+
+// RetryBudget is a token bucket shared by all calls
+// on a client to prevent retry storms
+// (safe for concurrent use by multiple goroutines).
+// Every call deposits Ratio tokens and MinPerSecond
+// tokens are added every second, up to MaxTokens;
+// every retry (or hedge) withdraws one token.
+// E.g., Ratio 0.1 allows retries of 10% of recent requests.
+type RetryBudget struct {
+	Ratio        float64
+	MinPerSecond float64
+	MaxTokens    float64
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time // last refill
+	blocked int64
+}
+
+// NewRetryBudget returns a full RetryBudget
+// holding at most 10 tokens or MinPerSecond if larger.
+func NewRetryBudget(ratio, minPerSecond float64) *RetryBudget {
+	limit := 10.0
+	if minPerSecond > limit {
+		limit = minPerSecond
+	}
+	return &RetryBudget{
+		Ratio:        ratio,
+		MinPerSecond: minPerSecond,
+		MaxTokens:    limit,
+		tokens:       limit,
+		last:         time.Now(),
+	}
+}
+
+// Blocked returns the number of retries blocked so far.
+func (b *RetryBudget) Blocked() int64 {
+	return atomic.LoadInt64(&b.blocked)
+}
+
+// Tokens returns the tokens available now.
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens
+}
+
+// refill adds tokens by MinPerSecond since last time;
+// the lock must be held.
+func (b *RetryBudget) refill() {
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.add(now.Sub(b.last).Seconds() * b.MinPerSecond)
+	}
+	b.last = now
+}
+
+// add adds n tokens up to MaxTokens; the lock must be held.
+func (b *RetryBudget) add(n float64) {
+	b.tokens += n
+	if b.tokens > b.MaxTokens {
+		b.tokens = b.MaxTokens
+	}
+}
+
+// deposit is called for every call.
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.add(b.Ratio)
+}
+
+// withdraw takes a token for a retry if available.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		atomic.AddInt64(&b.blocked, 1)
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,60 @@
+package web_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestRetryBudget(t *testing.T) {
+	a := assert.New(t)
+
+	b := web.NewRetryBudget(0.5, 0)
+	a.Equal(10.0, b.Tokens(), "Full at first")
+
+	b = web.NewRetryBudget(0, 100)
+	a.Equal(100.0, b.Tokens(), "At least MinPerSecond")
+}
+
+func TestClientDo_RetryBudget(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	b := web.NewRetryBudget(0, 0)
+	cl := web.NewClient(
+		web.WithRetryBudget(b),
+		web.WithBackoff(web.ConstantBackoff{Delay: time.Millisecond}),
+	)
+
+	// 10 tokens for 4 + 4 + 2 retries
+	for i := 0; i < 2; i++ {
+		n, _, _, err := cl.Do(newGet(t, server.URL), 5)
+		a.True(gaveUpOnStatus(err), "Within budget")
+		a.Equal(5, n, "All tries made")
+	}
+	a.Equal(int64(0), b.Blocked(), "Nothing blocked")
+
+	n, status, _, err := cl.Do(newGet(t, server.URL), 5)
+	a.True(errors.Is(err, web.ErrRetryBudget), "Budget spent")
+	a.Equal(3, n, "Retries stop")
+	a.Equal(http.StatusBadGateway, status, "Status of the last try")
+	a.Equal(int64(1), b.Blocked(), "One retry blocked")
+
+	n, _, _, err = cl.Do(newGet(t, server.URL), 5)
+	a.True(errors.Is(err, web.ErrRetryBudget), "Fail immediately")
+	a.Equal(1, n, "No retry")
+	a.Equal(int64(2), b.Blocked(), "Two retries blocked")
+
+	// requests deposit tokens
+	b.Ratio = 1
+	n, _, _, err = cl.Do(newGet(t, server.URL), 5)
+	a.True(errors.Is(err, web.ErrRetryBudget), "Budget spent again")
+	a.Equal(2, n, "One retry from the deposit")
+}
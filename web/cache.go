@@ -0,0 +1,393 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromCacheHeader is set on responses served by a CacheTransport,
+// to "1" if fresh or revalidated and "stale" if by stale-if-error.
+const FromCacheHeader = "X-From-Cache"
+
+// DefaultCacheMaxBody is the default CacheTransport.MaxBodySize.
+const DefaultCacheMaxBody = 1 << 20
+
+// CacheTransport is a http.RoundTripper caching responses to
+// GET requests per RFC 7234 as a private cache: fresh responses
+// are served from the Storage, stale ones are revalidated with
+// If-None-Match and If-Modified-Since, and unsafe methods
+// invalidate the URL. One variant per URL is kept.
+// Requests with their own conditional headers pass through.
+type CacheTransport struct {
+	// Transport makes the requests,
+	// http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	Storage   CacheStorage
+	// MaxBodySize of the responses stored, DefaultCacheMaxBody
+	// if <= 0; larger ones pass through with the live body.
+	MaxBodySize int64
+}
+
+// NewCacheTransport returns a CacheTransport over rt.
+func NewCacheTransport(rt http.RoundTripper, s CacheStorage) *CacheTransport {
+	return &CacheTransport{Transport: rt, Storage: s}
+}
+
+// cacheEntry is a stored response.
+type cacheEntry struct {
+	Status       int
+	Header       http.Header
+	Body         []byte
+	Vary         map[string]string // request headers selected by Vary
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// cacheableStatus are the status codes cacheable by default.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+func cacheKey(req *http.Request) string {
+	return req.URL.String()
+}
+
+func (t *CacheTransport) maxBodySize() int64 {
+	if t.MaxBodySize <= 0 {
+		return DefaultCacheMaxBody
+	}
+	return t.MaxBodySize
+}
+
+// RoundTrip serves req from the Storage if possible,
+// otherwise sends it and stores the response if allowed.
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	key := cacheKey(req)
+
+	if req.Method != http.MethodGet {
+		resp, err := rt.RoundTrip(req)
+		if err == nil && !isSafeMethod(req.Method) && resp.StatusCode < 400 {
+			t.Storage.Delete(key)
+		}
+		return resp, err
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok || isConditional(req) {
+		return rt.RoundTrip(req)
+	}
+
+	e := t.load(req)
+	out := req // the one sent
+	if e != nil {
+		if _, ok := reqCC["no-cache"]; !ok && e.fresh(reqCC, time.Now()) {
+			return e.response(req, time.Now(), "1"), nil
+		}
+		// revalidate
+		req2 := req.Clone(req.Context())
+		if etag := e.Header.Get("ETag"); etag != "" {
+			req2.Header.Set("If-None-Match", etag)
+		}
+		if lm := e.Header.Get("Last-Modified"); lm != "" {
+			req2.Header.Set("If-Modified-Since", lm)
+		}
+		out = req2
+	}
+
+	start := time.Now()
+	resp, err := rt.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && resp.StatusCode == http.StatusNotModified {
+		discard(resp)
+		e.update(resp.Header, start, time.Now())
+		t.store(key, e)
+		return e.response(req, time.Now(), "1"), nil
+	}
+	limit := t.maxBodySize()
+	if !storable(reqCC, resp) || resp.ContentLength > limit {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		// too large to store, the rest is still to read
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	e = &cacheEntry{
+		Status:       resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		Vary:         varyValues(req, resp.Header),
+		RequestTime:  start,
+		ResponseTime: time.Now(),
+	}
+	t.store(key, e)
+	return resp, nil
+}
+
+// staleIfError returns the stored response to req if allowed
+// by stale-if-error (RFC 5861), or nil.
+func (t *CacheTransport) staleIfError(req *http.Request) *cacheEntry {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	e := t.load(req)
+	if e == nil {
+		return nil
+	}
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["must-revalidate"]; ok {
+		return nil
+	}
+	limit, ok := ccSeconds(cc, "stale-if-error")
+	if d, reqOK := ccSeconds(parseCacheControl(req.Header), "stale-if-error"); reqOK {
+		limit, ok = d, true
+	}
+	if !ok || e.age(time.Now())-e.lifetime() > limit {
+		return nil
+	}
+	return e
+}
+
+// load returns the entry matching req, or nil.
+func (t *CacheTransport) load(req *http.Request) *cacheEntry {
+	data, ok := t.Storage.Get(cacheKey(req))
+	if !ok {
+		return nil
+	}
+	var e cacheEntry
+	if json.Unmarshal(data, &e) != nil {
+		return nil
+	}
+	for name, v := range e.Vary {
+		if strings.Join(headerValues(req.Header, name), ", ") != v {
+			return nil
+		}
+	}
+	return &e
+}
+
+func (t *CacheTransport) store(key string, e *cacheEntry) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		t.Storage.Set(key, data)
+	}
+}
+
+// response returns a new http.Response of e for req.
+func (e *cacheEntry) response(req *http.Request, now time.Time, from string) *http.Response {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.Itoa(int(e.age(now)/time.Second)))
+	h.Set(FromCacheHeader, from)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// update merges the headers of a 304 response into e.
+func (e *cacheEntry) update(h http.Header, requested, responded time.Time) {
+	for k, v := range h {
+		if k != "Content-Length" {
+			e.Header[k] = v
+		}
+	}
+	e.RequestTime, e.ResponseTime = requested, responded
+}
+
+// age is the current age (RFC 7234 section 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		if d := e.ResponseTime.Sub(date); d > 0 {
+			apparent = d
+		}
+	}
+	var ageValue time.Duration
+	if n, err := strconv.Atoi(e.Header.Get("Age")); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if apparent > corrected {
+		corrected = apparent
+	}
+	return corrected + now.Sub(e.ResponseTime)
+}
+
+// lifetime is the freshness lifetime (RFC 7234 section 4.2.1).
+func (e *cacheEntry) lifetime() time.Duration {
+	if d, ok := ccSeconds(parseCacheControl(e.Header), "max-age"); ok {
+		return d
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid dates are in the past
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	// heuristic freshness, 10% of the time since modified
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// fresh tells if e can be served without revalidation
+// given the request Cache-Control directives.
+func (e *cacheEntry) fresh(reqCC map[string]string, now time.Time) bool {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	age, lifetime := e.age(now), e.lifetime()
+	if d, ok := ccSeconds(reqCC, "max-age"); ok && age > d {
+		return false
+	}
+	if d, ok := ccSeconds(reqCC, "min-fresh"); ok && lifetime-age < d {
+		return false
+	}
+	if age < lifetime {
+		return true
+	}
+	if _, ok := cc["must-revalidate"]; ok {
+		return false
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if v == "" {
+			return true
+		}
+		d, ok := ccSeconds(reqCC, "max-stale")
+		return ok && age-lifetime <= d
+	}
+	return false
+}
+
+// storable tells if resp to a GET request can be stored.
+func storable(reqCC map[string]string, resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] || resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	// useless without freshness or validators
+	_, maxAge := cc["max-age"]
+	return maxAge || resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// varyValues returns the request headers selected by Vary.
+func varyValues(req *http.Request, h http.Header) map[string]string {
+	var m map[string]string
+	for _, v := range headerValues(h, "Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[name] = strings.Join(headerValues(req.Header, name), ", ")
+		}
+	}
+	return m
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func isConditional(req *http.Request) bool {
+	for _, k := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if req.Header.Get(k) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl returns the Cache-Control directives
+// by lower-cased name.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range headerValues(h, "Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// ccSeconds returns the delta-seconds of the directive.
+func ccSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
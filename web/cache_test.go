@@ -0,0 +1,180 @@
+package web_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestCacheTransport(t *testing.T) {
+	a := assert.New(t)
+
+	var count, notModified int32
+	cacheControl := "max-age=60"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(okResp)
+	}))
+	defer server.Close()
+
+	cl := &http.Client{Transport: web.NewCacheTransport(nil, web.NewMemoryCache(0))}
+	get := func() (*http.Response, []byte) {
+		resp, err := cl.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get()
+	a.Equal("", resp.Header.Get(web.FromCacheHeader), "Not from cache")
+	a.Equal(string(okResp), string(body), "Body from server")
+
+	resp, body = get()
+	a.Equal("1", resp.Header.Get(web.FromCacheHeader), "From cache")
+	a.Equal(string(okResp), string(body), "Body from cache")
+	a.Equal(int32(1), atomic.LoadInt32(&count), "Fresh one served")
+
+	// no-cache in the request revalidates
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := cl.Do(req)
+	a.NoError(err, "Revalidates")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode, "Not modified")
+	a.Equal(string(okResp), string(body), "Body from cache")
+	a.Equal(int32(1), atomic.LoadInt32(&notModified), "Conditional request")
+
+	// unsafe methods invalidate
+	resp, err = cl.Post(server.URL, "text/plain", nil)
+	a.NoError(err, "Posts")
+	resp.Body.Close()
+	cacheControl = "no-store"
+	resp, _ = get()
+	a.Equal("", resp.Header.Get(web.FromCacheHeader), "Invalidated")
+	resp, _ = get()
+	a.Equal("", resp.Header.Get(web.FromCacheHeader), "Not stored")
+	a.Equal(int32(5), atomic.LoadInt32(&count), "All went to the server")
+}
+
+func TestCacheTransport_Vary(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept")
+		w.Write([]byte(r.Header.Get("Accept")))
+	}))
+	defer server.Close()
+
+	cl := &http.Client{Transport: web.NewCacheTransport(nil, web.NewMemoryCache(0))}
+	get := func(accept string) string {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	a.Equal("text/plain", get("text/plain"), "First")
+	a.Equal("text/plain", get("text/plain"), "Same variant")
+	a.Equal(int32(1), atomic.LoadInt32(&count), "Served from cache")
+	a.Equal("text/html", get("text/html"), "Other variant")
+	a.Equal(int32(2), atomic.LoadInt32(&count), "Varies by Accept")
+}
+
+func TestClientDo_StaleIfError(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(errResp)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write(okResp)
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithCache(web.NewMemoryCache(10)), web.WithBackoff(web.ConstantBackoff{}))
+	_, status, body, err := cl.Do(newGet(t, server.URL), 1)
+	a.NoError(err, "First")
+	a.Equal(string(okResp), string(body), "From server")
+
+	tries, status, body, err := cl.Do(newGet(t, server.URL), 3)
+	a.NoError(err, "Stale served")
+	a.Equal(3, tries, "Gave up")
+	a.Equal(http.StatusOK, status, "Status of the stale one")
+	a.Equal(string(okResp), string(body), "Body of the stale one")
+
+	_, resp, err := cl.Stream(newGet(t, server.URL), 2)
+	a.NoError(err, "Stale streamed")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Equal("stale", resp.Header.Get(web.FromCacheHeader), "Marked stale")
+	a.Equal(string(okResp), string(body), "Body streamed")
+}
+
+func TestCacheTransport_MaxBodySize(t *testing.T) {
+	a := assert.New(t)
+
+	large := bytes.Repeat([]byte("0123456789"), 200)
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/chunked" {
+			// no Content-Length
+			w.Write(large[:1000])
+			w.(http.Flusher).Flush()
+			w.Write(large[1000:])
+			return
+		}
+		w.Write(large)
+	}))
+	defer server.Close()
+
+	cl := &http.Client{Transport: &web.CacheTransport{Storage: web.NewMemoryCache(0), MaxBodySize: 1024}}
+	for _, path := range []string{"/", "/chunked"} {
+		for i := 0; i < 2; i++ {
+			resp, err := cl.Get(server.URL + path)
+			a.NoError(err, "Gets "+path)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			a.Equal(large, body, "Whole body of "+path)
+			a.Equal("", resp.Header.Get(web.FromCacheHeader), "Not stored "+path)
+		}
+	}
+	a.Equal(int32(4), atomic.LoadInt32(&count), "All from the server")
+
+	// no try, no panic
+	wcl := web.NewClient(web.WithCache(web.NewMemoryCache(0)))
+	_, resp, err := wcl.Stream(newGet(t, server.URL), 0)
+	a.True(resp == nil && err == nil, "Nothing done")
+}
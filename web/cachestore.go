@@ -0,0 +1,133 @@
+package web
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheStorage stores cached responses by key
+// (safe for concurrent use by multiple goroutines).
+type CacheStorage interface {
+	Get(key string) (value []byte, ok bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// memoryCache implements CacheStorage as an in-memory LRU.
+type memoryCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache returns an in-memory CacheStorage
+// evicting the least recently used entries beyond maxEntries
+// (no limit if <= 0).
+func NewMemoryCache(maxEntries int) CacheStorage {
+	return &memoryCache{
+		max:   maxEntries,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	m.ll.MoveToFront(e)
+	return e.Value.(*memoryItem).value, true
+}
+
+func (m *memoryCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.items[key]; ok {
+		e.Value.(*memoryItem).value = value
+		m.ll.MoveToFront(e)
+		return
+	}
+	m.items[key] = m.ll.PushFront(&memoryItem{key, value})
+	if m.max > 0 && m.ll.Len() > m.max {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryItem).key)
+	}
+}
+
+func (m *memoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.items[key]; ok {
+		m.ll.Remove(e)
+		delete(m.items, key)
+	}
+}
+
+// diskCache implements CacheStorage with a file per entry.
+type diskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a CacheStorage keeping entries
+// as files in dir, which is created if missing.
+func NewDiskCache(dir string) (CacheStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir}, nil
+}
+
+// path names the file by the hashed key.
+func (d *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *diskCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (d *diskCache) Set(key string, value []byte) {
+	// write then rename so that readers never see a partial file
+	f, err := ioutil.TempFile(d.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if os.Rename(f.Name(), d.path(key)) != nil {
+		os.Remove(f.Name())
+	}
+}
+
+func (d *diskCache) Delete(key string) {
+	os.Remove(d.path(key))
+}
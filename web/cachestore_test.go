@@ -0,0 +1,62 @@
+package web_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestMemoryCache(t *testing.T) {
+	a := assert.New(t)
+
+	s := web.NewMemoryCache(2)
+	s.Set("a", []byte("1"))
+	s.Set("b", []byte("2"))
+	s.Get("a") // b is the least recently used
+	s.Set("c", []byte("3"))
+
+	_, ok := s.Get("b")
+	a.True(!ok, "Evicts the LRU")
+	v, ok := s.Get("a")
+	a.True(ok, "Keeps a")
+	a.Equal("1", string(v), "Value of a")
+
+	s.Set("a", []byte("4"))
+	v, _ = s.Get("a")
+	a.Equal("4", string(v), "Overwrites a")
+	s.Delete("a")
+	_, ok = s.Get("a")
+	a.True(!ok, "Deletes a")
+}
+
+func TestDiskCache(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := web.NewDiskCache(dir)
+	a.NoError(err, "Creates the cache")
+	_, ok := s.Get("http://example.com/")
+	a.True(!ok, "Misses")
+
+	s.Set("http://example.com/", []byte("hello"))
+	v, ok := s.Get("http://example.com/")
+	a.True(ok, "Hits")
+	a.Equal("hello", string(v), "Value stored")
+
+	// survives reopening
+	s, _ = web.NewDiskCache(dir)
+	v, _ = s.Get("http://example.com/")
+	a.Equal("hello", string(v), "Value persisted")
+
+	s.Delete("http://example.com/")
+	_, ok = s.Get("http://example.com/")
+	a.True(!ok, "Deleted")
+}
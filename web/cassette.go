@@ -0,0 +1,266 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// ErrUnmatched is reported by a Recorder in replay mode
+// for a request matching no interaction in the cassette.
+var ErrUnmatched = errors.New("web: no matching interaction in cassette")
+
+// CassetteMode tells a Recorder to record or replay.
+type CassetteMode int
+
+// Recorder modes.
+const (
+	ModeReplay CassetteMode = iota
+	ModeRecord
+)
+
+// RecordedRequest is the request part of an Interaction.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"` // base64 in JSON
+}
+
+// RecordedResponse is the response part of an Interaction.
+type RecordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"` // base64 in JSON
+}
+
+// Interaction is a recorded request/response pair.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Matcher tells if the request (with its body read)
+// matches a recorded one.
+type Matcher func(req *http.Request, body []byte, rec RecordedRequest) bool
+
+// MatchMethodURL matches on method and URL;
+// it is the default Matcher of a Recorder.
+func MatchMethodURL(req *http.Request, body []byte, rec RecordedRequest) bool {
+	return req.Method == rec.Method && req.URL.String() == rec.URL
+}
+
+// MatchBody matches on body.
+func MatchBody(req *http.Request, body []byte, rec RecordedRequest) bool {
+	return bytes.Equal(body, rec.Body)
+}
+
+// MatchHeaders returns a Matcher on the values of the headers.
+func MatchHeaders(names ...string) Matcher {
+	return func(req *http.Request, body []byte, rec RecordedRequest) bool {
+		for _, name := range names {
+			if req.Header.Get(name) != rec.Header.Get(name) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAll returns a Matcher that matches if all ms match.
+func MatchAll(ms ...Matcher) Matcher {
+	return func(req *http.Request, body []byte, rec RecordedRequest) bool {
+		for _, m := range ms {
+			if !m(req, body, rec) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RedactHeaders returns a redaction func for Recorder.Redact
+// that masks the values of the headers in both
+// the request and response.
+func RedactHeaders(names ...string) func(*Interaction) {
+	return func(in *Interaction) {
+		for _, name := range names {
+			for _, h := range []http.Header{in.Request.Header, in.Response.Header} {
+				if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+					h.Set(name, "REDACTED")
+				}
+			}
+		}
+	}
+}
+
+// Recorder is a record/replay http.RoundTripper for testing,
+// e.g., plugged in with WithHTTPClient
+// (safe for concurrent use by multiple goroutines).
+// In ModeRecord it sends requests by Transport and saves
+// the interactions to the JSON cassette file at Path;
+// in ModeReplay it serves the interactions back in order,
+// each one matching by Matcher at most once,
+// and fails with ErrUnmatched if none is left.
+type Recorder struct {
+	Path      string
+	Mode      CassetteMode
+	Transport http.RoundTripper // http.DefaultTransport if nil
+	Matcher   Matcher           // MatchMethodURL if nil
+	// Redact, if not nil, is applied to every interaction
+	// before it is saved, e.g., to mask secrets.
+	Redact func(*Interaction)
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder returns a Recorder on the cassette file at path;
+// the cassette is loaded in ModeReplay.
+func NewRecorder(path string, mode CassetteMode) (*Recorder, error) {
+	r := &Recorder{Path: path, Mode: mode}
+	if mode != ModeReplay {
+		return r, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, err
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Interactions returns a copy of the interactions
+// recorded or loaded.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Unused returns the interactions not replayed yet,
+// e.g., to check that a test makes all expected requests.
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []Interaction
+	for i, in := range r.interactions {
+		if !r.used[i] {
+			list = append(list, in)
+		}
+	}
+	return list
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Mode == ModeRecord {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	rt := r.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	out := req.Clone(req.Context())
+	if req.Body != nil {
+		out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := rt.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	in := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   body,
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header.Clone(),
+			Body:   respBody,
+		},
+	}
+	if r.Redact != nil {
+		r.Redact(&in)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, in)
+	r.used = append(r.used, true)
+	if err = r.save(); err != nil {
+		return nil, err
+	}
+
+	// the caller gets the original response
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// save writes the cassette; the lock must be held.
+func (r *Recorder) save() error {
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.Path, data, 0644)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	match := r.Matcher
+	if match == nil {
+		match = MatchMethodURL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || !match(req, body, in.Request) {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrUnmatched, req.Method, req.URL)
+}
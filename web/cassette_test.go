@@ -0,0 +1,121 @@
+package web_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestRecorder(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	server := httptest.NewServer(http.HandlerFunc(EchoHandler))
+
+	// record
+	rec, err := web.NewRecorder(path, web.ModeRecord)
+	a.NoError(err, "New recorder")
+	rec.Redact = web.RedactHeaders("Authorization")
+	cl := web.NewClient(web.WithHTTPClient(&http.Client{Transport: rec}))
+
+	req, _ := web.NewJSONRequest("PUT", server.URL+"/items/1", item{Name: "foo"})
+	req.Header.Set("Authorization", "Bearer secret")
+	_, status, body, err := cl.Do(req, 1)
+	a.NoError(err, "Recorded")
+	a.Equal(http.StatusOK, status, "Real response")
+	a.Equal(`{"method":"PUT","name":"foo"}`, string(body), "Real body")
+
+	_, _, _, err = cl.Do(newGet(t, server.URL+"/items/2"), 1)
+	a.NoError(err, "Recorded")
+	server.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	a.True(!strings.Contains(string(data), "secret"), "Secret redacted")
+	a.True(strings.Contains(string(data), "REDACTED"), "Secret masked")
+	a.Equal(2, len(rec.Interactions()), "2 interactions")
+
+	// replay with the server gone
+	rec, err = web.NewRecorder(path, web.ModeReplay)
+	a.NoError(err, "Load cassette")
+	rec.Matcher = web.MatchAll(web.MatchMethodURL, web.MatchBody)
+	cl = web.NewClient(web.WithHTTPClient(&http.Client{Transport: rec}))
+
+	req, _ = web.NewJSONRequest("PUT", server.URL+"/items/1", item{Name: "foo"})
+	_, status, body, err = cl.Do(req, 1)
+	a.NoError(err, "Replayed")
+	a.Equal(http.StatusOK, status, "Recorded status")
+	a.Equal(`{"method":"PUT","name":"foo"}`, string(body), "Recorded body")
+	a.Equal(1, len(rec.Unused()), "One left")
+
+	// body differs
+	req, _ = web.NewJSONRequest("PUT", server.URL+"/items/1", item{Name: "bar"})
+	_, _, _, err = cl.Do(req, 1)
+	a.True(errors.Is(err, web.ErrUnmatched), "Fail on unmatched body")
+
+	_, _, _, err = cl.Do(newGet(t, server.URL+"/items/2"), 1)
+	a.NoError(err, "Replayed")
+	a.Equal(0, len(rec.Unused()), "All replayed")
+
+	_, _, _, err = cl.Do(newGet(t, server.URL+"/items/2"), 1)
+	a.True(errors.Is(err, web.ErrUnmatched), "Each interaction replays once")
+
+	_, err = web.NewRecorder(filepath.Join(dir, "missing.json"), web.ModeReplay)
+	a.NotNil(err, "Missing cassette")
+}
+
+func TestMatchHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	req := newGet(t, "/")
+	req.Header.Set("X-Tenant", "a")
+	rec := web.RecordedRequest{Header: http.Header{"X-Tenant": {"a"}}}
+	a.True(web.MatchHeaders("X-Tenant")(req, nil, rec), "Same header")
+	rec.Header.Set("X-Tenant", "b")
+	a.True(!web.MatchHeaders("X-Tenant")(req, nil, rec), "Different header")
+}
+
+func TestRecorder_Binary(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	payload := []byte{0xff, 0xfe, 0x00, 0x80}
+	server := httptest.NewServer(DummyHandler(http.StatusOK, payload))
+
+	rec, err := web.NewRecorder(path, web.ModeRecord)
+	a.NoError(err, "New recorder")
+	cl := web.NewClient(web.WithHTTPClient(&http.Client{Transport: rec}))
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewReader(payload))
+	_, _, _, err = cl.Do(req, 1)
+	a.NoError(err, "Recorded")
+	server.Close()
+
+	rec, err = web.NewRecorder(path, web.ModeReplay)
+	a.NoError(err, "Load cassette")
+	rec.Matcher = web.MatchAll(web.MatchMethodURL, web.MatchBody)
+	cl = web.NewClient(web.WithHTTPClient(&http.Client{Transport: rec}))
+	req, _ = http.NewRequest("POST", server.URL, bytes.NewReader(payload))
+	_, _, body, err := cl.Do(req, 1)
+	a.NoError(err, "Binary request body matched")
+	a.Equal(payload, body, "Byte-exact replay")
+}
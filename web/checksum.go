@@ -0,0 +1,171 @@
+package web
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Digest algorithms, named as in the Digest header.
+const (
+	MD5    = "MD5"
+	SHA1   = "SHA"
+	SHA256 = "SHA-256"
+	SHA512 = "SHA-512"
+)
+
+var digestHashes = map[string]func() hash.Hash{
+	MD5:    md5.New,
+	SHA1:   sha1.New,
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+}
+
+// digestAlgorithm returns the canonical name of
+// the algorithm, case-insensitive and with or
+// without dash, or "" if unsupported.
+func digestAlgorithm(name string) string {
+	switch strings.Replace(strings.ToUpper(strings.TrimSpace(name)), "-", "", 1) {
+	case "MD5":
+		return MD5
+	case "SHA", "SHA1":
+		return SHA1
+	case "SHA256":
+		return SHA256
+	case "SHA512":
+		return SHA512
+	}
+	return ""
+}
+
+// Digest is an expected digest of a download.
+type Digest struct {
+	// Algorithm is MD5, SHA1, SHA256 or SHA512, i.e., "MD5",
+	// "SHA", "SHA-256" or "SHA-512", or another common spelling
+	// of them, e.g., "sha1", "SHA-1" or "SHA256".
+	Algorithm string
+	Sum       []byte
+}
+
+// NewDigest returns the Digest of a hex-encoded sum.
+func NewDigest(algorithm, hexSum string) (Digest, error) {
+	a := digestAlgorithm(algorithm)
+	if a == "" {
+		return Digest{}, fmt.Errorf("web: unsupported digest algorithm %q", algorithm)
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return Digest{}, err
+	}
+	return Digest{a, sum}, nil
+}
+
+// ChecksumError is returned if a download
+// does not match the expected Digest.
+type ChecksumError struct {
+	Algorithm        string
+	Expected, Actual []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("web: %s checksum mismatch: expected %x, got %x", e.Algorithm, e.Expected, e.Actual)
+}
+
+// verifier hashes a download to check the digests.
+type verifier struct {
+	want   []Digest
+	hashes map[string]hash.Hash
+}
+
+func newVerifier(digests []Digest) (*verifier, error) {
+	v := &verifier{hashes: make(map[string]hash.Hash)}
+	for _, d := range digests {
+		if err := v.expect(d); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *verifier) expect(d Digest) error {
+	algorithm := digestAlgorithm(d.Algorithm)
+	if algorithm == "" {
+		return fmt.Errorf("web: unsupported digest algorithm %q", d.Algorithm)
+	}
+	d.Algorithm = algorithm
+	v.want = append(v.want, d)
+	if v.hashes[d.Algorithm] == nil {
+		v.hashes[d.Algorithm] = digestHashes[d.Algorithm]()
+	}
+	return nil
+}
+
+// expectHeader expects the digests in the Digest header,
+// and Content-MD5 if full is true (not of a range).
+func (v *verifier) expectHeader(h http.Header, full bool) {
+	if s := h.Get("Content-MD5"); full && s != "" {
+		if sum, err := base64.StdEncoding.DecodeString(s); err == nil {
+			v.expect(Digest{MD5, sum})
+		}
+	}
+	for _, field := range headerValues(h, "Digest") {
+		for _, item := range strings.Split(field, ",") {
+			i := strings.IndexByte(item, '=')
+			if i < 0 {
+				continue
+			}
+			algorithm := digestAlgorithm(item[:i])
+			sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(item[i+1:]))
+			if err == nil && algorithm != "" {
+				v.expect(Digest{algorithm, sum})
+			}
+		}
+	}
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// restart hashes again from the first n bytes of file.
+func (v *verifier) restart(file string, n int64) error {
+	if len(v.hashes) == 0 {
+		return nil
+	}
+	for _, h := range v.hashes {
+		h.Reset()
+	}
+	if n == 0 {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(v, f, n)
+	return err
+}
+
+// verify returns a *ChecksumError on any mismatch.
+func (v *verifier) verify() error {
+	for _, d := range v.want {
+		if sum := v.hashes[d.Algorithm].Sum(nil); !bytes.Equal(sum, d.Sum) {
+			return &ChecksumError{d.Algorithm, d.Sum, sum}
+		}
+	}
+	return nil
+}
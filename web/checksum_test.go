@@ -0,0 +1,126 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestNewDigest(t *testing.T) {
+	a := assert.New(t)
+
+	d, err := web.NewDigest("sha-256", "00ff")
+	a.NoError(err, "Valid digest")
+	a.Equal(web.SHA256, d.Algorithm, "Algorithm normalized")
+	a.Equal([]byte{0, 0xff}, d.Sum, "Sum decoded")
+
+	for _, name := range []string{"SHA-1", "sha1", "SHA1", "sha"} {
+		d, err = web.NewDigest(name, "00ff")
+		a.NoError(err, name+" supported")
+		a.Equal(web.SHA1, d.Algorithm, name+" normalized")
+	}
+	for _, name := range []string{"SHA256", "sha256", "Sha-256"} {
+		d, _ = web.NewDigest(name, "00ff")
+		a.Equal(web.SHA256, d.Algorithm, name+" normalized")
+	}
+	d, _ = web.NewDigest("sha512", "00ff")
+	a.Equal(web.SHA512, d.Algorithm, "sha512 normalized")
+	d, _ = web.NewDigest("md5", "00ff")
+	a.Equal(web.MD5, d.Algorithm, "md5 normalized")
+
+	_, err = web.NewDigest("crc32", "00ff")
+	a.True(err != nil, "Unsupported algorithm")
+	_, err = web.NewDigest(web.MD5, "xyz")
+	a.True(err != nil, "Invalid hex")
+}
+
+func TestDownloader_Digests(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	sum := sha256.Sum256(content)
+	good, _ := web.NewDigest(web.SHA256, hex.EncodeToString(sum[:]))
+	bad, _ := web.NewDigest(web.SHA256, hex.EncodeToString(sum[1:]))
+
+	h, _ := RangeHandler(content, `"v1"`, 1)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	// interrupted, then resumed by another call
+	d := &web.Downloader{MaxTries: 1, Digests: []web.Digest{good}}
+	a.True(d.Download(context.Background(), server.URL, file) != nil, "Interrupted")
+	a.NoError(d.Download(context.Background(), server.URL, file), "Verified with the part")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+
+	other := filepath.Join(dir, "other")
+	d = &web.Downloader{Digests: []web.Digest{bad}}
+	err := d.Download(context.Background(), server.URL, other)
+	var ce *web.ChecksumError
+	a.True(errors.As(err, &ce), "Mismatch")
+	a.Equal(web.SHA256, ce.Algorithm, "Algorithm of the mismatch")
+	a.Equal(sum[:], ce.Actual, "Actual sum")
+	_, err = os.Stat(other + ".part")
+	a.True(os.IsNotExist(err), "Part deleted")
+	_, err = os.Stat(other)
+	a.True(os.IsNotExist(err), "No file")
+
+	// in parallel
+	d = &web.Downloader{Workers: 2, ChunkSize: 3000, Digests: []web.Digest{bad}}
+	err = d.Download(context.Background(), server.URL, other)
+	a.True(errors.As(err, &ce), "Mismatch in parallel")
+	_, err = os.Stat(other + ".part")
+	a.True(os.IsNotExist(err), "Part deleted")
+}
+
+func TestDownloader_DigestHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	md5Sum := md5.Sum(content)
+	shaSum := sha256.Sum256(content)
+	var contentMD5, digest string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", contentMD5)
+		w.Header().Set("Digest", digest)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	d := web.NewDownloader(nil, 0)
+
+	contentMD5 = base64.StdEncoding.EncodeToString(md5Sum[:])
+	digest = "SHA-256=" + base64.StdEncoding.EncodeToString(shaSum[:])
+	a.NoError(d.Download(context.Background(), server.URL, file), "Verified by headers")
+
+	digest = "sha-256=" + base64.StdEncoding.EncodeToString(md5Sum[:])
+	err := d.Download(context.Background(), server.URL, file+"2")
+	var ce *web.ChecksumError
+	a.True(errors.As(err, &ce), "Digest mismatch")
+
+	contentMD5, digest = base64.StdEncoding.EncodeToString(shaSum[:16]), ""
+	err = d.Download(context.Background(), server.URL, file+"3")
+	a.True(errors.As(err, &ce), "Content-MD5 mismatch")
+	a.Equal(web.MD5, ce.Algorithm, "MD5")
+}
@@ -0,0 +1,165 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ErrContentChanged is returned if the content changes
+// while downloading it in chunks.
+var ErrContentChanged = errors.New("web: content changed during download")
+
+// probeRanges tells if the server accepts byte ranges for url,
+// returning the size and the headers of the content; it is best
+// effort with a single HEAD try, any failure meaning no ranges.
+func probeRanges(ctx context.Context, cl Client, url string) (size int64, h http.Header, ok bool) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return
+	}
+	_, resp, err := cl.Stream(req.WithContext(ctx), 1)
+	discard(resp)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	ok = resp.Header.Get("Accept-Ranges") == "bytes" && resp.ContentLength > 0
+	return resp.ContentLength, resp.Header, ok
+}
+
+// offsetWriter writes to f sequentially from off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// downloadChunks downloads url of size to file with
+// d.Workers fetching the chunks into a preallocated part.
+func (d *Downloader) downloadChunks(ctx context.Context, cl Client, url, file string, size int64, validator string, v *verifier, m *meter) (err error) {
+	part, meta := file+partSuffix, file+metaSuffix
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			// the chunks done are unknown
+			os.Remove(part)
+		}
+	}()
+	if err = out.Truncate(size); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan int64)
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		failed error
+	)
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range chunks {
+				end := start + d.chunkSize() - 1
+				if end >= size {
+					end = size - 1
+				}
+				if e := d.fetchChunk(ctx, cl, url, validator, out, m, start, end); e != nil {
+					once.Do(func() {
+						failed = e
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for start := int64(0); start < size; start += d.chunkSize() {
+		select {
+		case chunks <- start:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if failed != nil {
+		return failed
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = v.restart(part, size); err != nil {
+		return
+	}
+	return finishDownload(out, part, meta, file, v)
+}
+
+// fetchChunk downloads the bytes from first to last into out,
+// resuming from where an interrupted body stops.
+func (d *Downloader) fetchChunk(ctx context.Context, cl Client, url, validator string, out *os.File, m *meter, first, last int64) error {
+	var r resumer
+	for {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+
+		tries, resp, err := cl.Stream(req.WithContext(ctx), d.maxTries())
+		if err != nil {
+			discard(resp)
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			discard(resp)
+			return ErrContentChanged
+		default:
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			discard(resp)
+			return &StatusError{Status: resp.StatusCode, Body: body, Tries: tries}
+		}
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != first {
+			discard(resp)
+			return fmt.Errorf("web: unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+
+		body := d.watch(resp.Body)
+		n, err := io.Copy(&offsetWriter{out, first}, m.reader(ctx, io.LimitReader(body, last-first+1)))
+		body.Close()
+		first += n
+		if first > last {
+			return nil
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if !r.wait(ctx, d, n > 0) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestDownloader_Chunks(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var (
+		mu                sync.Mutex
+		ranges            = make(map[string]int)
+		inFlight, maxSeen int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		ranges[rng]++
+		cut := rng == "bytes=20000-29999" && ranges[rng] == 1
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("ETag", `"v1"`)
+		if cut {
+			// the chunk is interrupted
+			w.Header().Set("Content-Range", "bytes 20000-29999/"+strconv.Itoa(len(content)))
+			w.Header().Set("Content-Length", "10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[20000:25000])
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{Workers: 4, ChunkSize: 10000, Backoff: web.ConstantBackoff{}}
+	a.NoError(d.Download(context.Background(), server.URL, file), "Downloads")
+
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content assembled")
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal(12, len(ranges), "Probe, 10 chunks and a resumed one")
+	a.Equal(1, ranges["bytes=25000-29999"], "Failed chunk resumed on its own")
+	a.True(maxSeen <= 4, "Bounded workers")
+}
+
+func TestDownloader_ChunksChanged(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var mu sync.Mutex
+	n := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		etag := `"v1"`
+		if n > 3 {
+			etag = `"v2"`
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{Workers: 2, ChunkSize: 10000}
+	err := d.Download(context.Background(), server.URL, file)
+	a.True(errors.Is(err, web.ErrContentChanged), "Changed")
+	_, err = os.Stat(file + ".part")
+	a.True(os.IsNotExist(err), "No part left")
+	_, err = os.Stat(file)
+	a.True(os.IsNotExist(err), "No file")
+}
+
+func TestDownloader_NoRanges(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	server := httptest.NewServer(DummyHandler(http.StatusOK, content))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{Workers: 4, ChunkSize: 10000}
+	a.NoError(d.Download(context.Background(), server.URL, file), "Falls back")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+}
+
+func TestDownloader_ProbeFails(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	var heads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			atomic.AddInt32(&heads, 1)
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{Workers: 4, ChunkSize: 10000, MaxTries: 3}
+	a.NoError(d.Download(context.Background(), server.URL, file), "Falls back")
+	a.Equal(int32(1), atomic.LoadInt32(&heads), "Probed once")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+}
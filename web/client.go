@@ -5,22 +5,35 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
 	"time"
 )
 
 // NewJSONPost returns a Request with json encoded and header set.
 func NewJSONPost(url string, v interface{}) (*http.Request, error) {
+	return NewJSONRequest("POST", url, v)
+}
+
+// NewJSONRequest returns a Request of the method
+// with json encoded and header set.
+func NewJSONRequest(method, url string, v interface{}) (*http.Request, error) {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
@@ -34,6 +47,21 @@ func NewJSONPost(url string, v interface{}) (*http.Request, error) {
 func RequestWithClose(cl *http.Client, req *http.Request) (status int, body []byte, err error) {
 	var resp *http.Response
 
+	resp, body, err = requestWithClose(cl, req, 0)
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	return
+}
+
+// ErrBodyTooLarge is reported when the response body
+// exceeds the client's max body size.
+var ErrBodyTooLarge = errors.New("web: response body too large")
+
+// requestWithClose is RequestWithClose that also returns
+// the response (with Body closed) for its headers;
+// it reads at most limit bytes of the body if limit > 0.
+func requestWithClose(cl *http.Client, req *http.Request, limit int64) (resp *http.Response, body []byte, err error) {
 	resp, err = cl.Do(req)
 	// Close() iff resp did return
 	if resp != nil {
@@ -44,13 +72,16 @@ func RequestWithClose(cl *http.Client, req *http.Request) (status int, body []by
 		return
 	}
 
-	status = resp.StatusCode
-
-	body, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if limit <= 0 {
+		body, err = ioutil.ReadAll(resp.Body)
 		return
 	}
 
+	// read one more byte to tell if it exceeds
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err == nil && int64(len(body)) > limit {
+		body, err = nil, ErrBodyTooLarge
+	}
 	return
 }
 
@@ -63,14 +94,115 @@ func ShouldRetry(statusCode int) bool {
 		(statusCode >= 500 && statusCode <= 599)
 }
 
-// IsTimeoutErr checks if the error is a timeout.
+// IsTimeoutErr checks if the error (or any error it wraps)
+// is a timeout.
 func IsTimeoutErr(e error) bool {
-	if err, ok := e.(net.Error); ok {
+	var err net.Error
+	if errors.As(e, &err) {
 		return err.Timeout()
 	}
 	return false
 }
 
+var (
+	// ErrMaxTries is reported when Do has used up all its tries.
+	ErrMaxTries = errors.New("web: max tries exhausted")
+	// ErrContextDone is reported when the request context is
+	// cancelled or its deadline passes before all tries are made.
+	ErrContextDone = errors.New("web: request context done")
+)
+
+// Attempt records a single try made by Client.Do.
+type Attempt struct {
+	Start    time.Time
+	Duration time.Duration
+	Backoff  time.Duration // slept before the try
+	Status   int           // 0 if no response
+	Err      error
+}
+
+func (a Attempt) String() string {
+	outcome := strconv.Itoa(a.Status)
+	if a.Err != nil {
+		outcome = a.Err.Error()
+	}
+	return fmt.Sprintf("%s in %s after %s backoff", outcome, a.Duration, a.Backoff)
+}
+
+// GiveUpError is returned by Client.Do when it stops retrying
+// a failing request; Reason tells which ran out first,
+// ErrMaxTries or ErrContextDone (or ErrCircuitOpen, ErrRetryBudget),
+// and Attempts records every try made.
+// Err is nil if the last try got a status to retry.
+// Both Reason and the underlying Err (if any) match errors.Is.
+type GiveUpError struct {
+	Reason   error
+	Tries    int
+	Err      error
+	Attempts []Attempt
+}
+
+func (e *GiveUpError) Error() string {
+	msg := fmt.Sprintf("%s after %d tries", e.Reason, e.Tries)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	for i, a := range e.Attempts {
+		msg += fmt.Sprintf("; #%d %s", i+1, a)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *GiveUpError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Reason.
+func (e *GiveUpError) Is(target error) bool {
+	return target == e.Reason
+}
+
+// sleepContext pauses for d or until ctx is done,
+// whichever comes first; it returns ctx.Err() for the latter.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ParseRetryAfter parses the Retry-After header value,
+// in either delta-seconds or HTTP-date form, into the delay
+// relative to now; ok is false if the value is absent or invalid.
+func ParseRetryAfter(value string, now time.Time) (d time.Duration, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	// a date in the past means retry now
+	if d = t.Sub(now); d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
 // Backoff implements the exponential backoff algorithm
 // with jitter for performing remote calls.
 // It use an alternative method as described in
@@ -95,18 +227,47 @@ func (b *Backoff) Next(previous int) int {
 	return sleep
 }
 
+// Sleep implements BackoffStrategy with Next
+// in milliseconds; it ignores retry.
+func (b Backoff) Sleep(retry int, previous time.Duration) time.Duration {
+	return time.Duration(b.Next(int(previous/time.Millisecond))) * time.Millisecond
+}
+
+// Cap implements BackoffStrategy.
+func (b Backoff) Cap() time.Duration {
+	return time.Duration(b.MaxSleep) * time.Millisecond
+}
+
 // Client provides additional features upon http.Client,
 // e.g., io Reader handle and request retry with backoff.
 type Client interface {
 	// Do sends the request with at most maxTries time.
-	// Retries happen in following conditions:
+	// Retries happen in following conditions by default
+	// (see WithRetryPolicy for custom ones):
 	// 1. timeout error occurs;
-	// 2. should-retry status code is returned.
+	// 2. should-retry status code is returned
+	//    (also 429 if RespectRetryAfter is set);
+	// and only if the request IsIdempotent unless RetryAnyMethod.
 	// It also normalize the HTTP response as:
 	// (tries, status int, body []byte, err error),
 	// for #requests made, status code for the final request,
 	// response body and error respectively.
+	// The whole loop, backoff included, stops as soon as
+	// the request context is done; a *GiveUpError is returned
+	// with the history of tries when it gives up: with an error,
+	// or once out of tries on a status to retry, in which case
+	// its Err is nil and the final status and body are returned.
 	Do(req *http.Request, maxTries int) (tries, status int, body []byte, err error)
+
+	// Stream is Do without buffering the response body:
+	// it retries the same way (on errors and status codes,
+	// before the body is read), then returns the final response
+	// with the live Body, which the caller must close.
+	// As with Do, once out of tries on a status to retry, the final
+	// response is returned with a *GiveUpError (its Err nil) and
+	// must be closed too; no response comes with other ones.
+	// Hedging does not apply to it.
+	Stream(req *http.Request, maxTries int) (tries int, resp *http.Response, err error)
 }
 
 // client implements the Client interface.
@@ -114,46 +275,412 @@ type Client interface {
 // (safe for concurrent use by multiple goroutines).
 type client struct {
 	timeoutOnly bool // only retry for timeout error
-	cl          *http.Client
-	bk          Backoff
+	retryAfter  bool // honor Retry-After and retry 429
+	anyMethod   bool // retry non-idempotent requests as well
+	genKey      bool // attach Idempotency-Key if missing
+	policy      RetryPolicy
+	breaker     *Breaker
+	hedging     *Hedging
+	budget      *RetryBudget
+	mws         []Middleware
+	observer    Observer
+	limiter     *RateLimiter
+	conns       *ConcurrencyLimiter
+	flights     *flightGroup
+	cache       *CacheTransport
+	mirrors     *Mirrors
+	balancer    *Balancer
+	maxBody     int64 // max response body size, no limit if <= 0
+
+	attemptTimeout time.Duration // for each try, no limit if <= 0
+	totalTimeout   time.Duration // for all tries, no limit if <= 0
+
+	cl *http.Client
+	bk BackoffStrategy
 }
 
 // NOTICE: retry works for request with no body only before go1.9.
 func (c *client) Do(req *http.Request, maxTries int) (tries, status int, body []byte, err error) {
-	// 0 will trigger setting wait to base
-	wait := 0
+	if c.flights != nil && canCoalesce(req) {
+		return c.flights.do(c, req, maxTries)
+	}
+	return c.do(req, maxTries)
+}
+
+// do is Do without coalescing.
+func (c *client) do(req *http.Request, maxTries int) (tries, status int, body []byte, err error) {
+	policy, retryable, err := c.prepare(req)
+	if err != nil {
+		return
+	}
+	req = withCallID(req)
+	if c.mirrors != nil {
+		req = c.mirrors.withOrder(req)
+	}
+	if c.totalTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.totalTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	if c.hedging != nil && retryable && canReplay(req) {
+		tries, status, body, err = c.doHedged(req, maxTries, policy)
+	} else {
+		var resp *http.Response
+		tries, resp, body, err = c.retry(req, maxTries, policy, retryable, false)
+		if resp != nil {
+			status = resp.StatusCode
+		}
+	}
+
+	if c.cache != nil && (err != nil || ShouldRetry(status)) {
+		if e := c.cache.staleIfError(req); e != nil {
+			status, body, err = e.Status, e.Body, nil
+		}
+	}
+	return
+}
+
+func (c *client) Stream(req *http.Request, maxTries int) (tries int, resp *http.Response, err error) {
+	policy, retryable, err := c.prepare(req)
+	if err != nil {
+		return
+	}
+	req = withCallID(req)
+	if c.mirrors != nil {
+		req = c.mirrors.withOrder(req)
+	}
+
+	if c.totalTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.totalTimeout)
+		req = req.WithContext(ctx)
+		// the deadline covers reading the body
+		defer func() {
+			if resp != nil {
+				resp.Body = &onCloseBody{ReadCloser: resp.Body, fn: cancel}
+			} else {
+				cancel()
+			}
+		}()
+	}
+
+	tries, resp, _, err = c.retry(req, maxTries, policy, retryable, true)
+	if c.cache != nil && (err != nil || resp != nil && ShouldRetry(resp.StatusCode)) {
+		if e := c.cache.staleIfError(req); e != nil {
+			if resp != nil {
+				discard(resp)
+			}
+			resp, err = e.response(req, time.Now(), "stale"), nil
+		}
+	}
+	return
+}
+
+// prepare returns the RetryPolicy and tells if req is retryable,
+// attaching an Idempotency-Key to it if needed.
+func (c *client) prepare(req *http.Request) (policy RetryPolicy, retryable bool, err error) {
+	// the same key is sent on every try
+	if c.genKey && !isIdempotentMethod(req.Method) && req.Header.Get(IdempotencyKeyHeader) == "" {
+		var key string
+		if key, err = NewIdempotencyKey(); err != nil {
+			return
+		}
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return c.retryPolicy(), c.anyMethod || IsIdempotent(req), nil
+}
+
+// retry is the sequential retry loop that returns the last response;
+// its Body is left open for streaming, or read into body otherwise.
+func (c *client) retry(req *http.Request, maxTries int, policy RetryPolicy, retryable, stream bool) (tries int, resp *http.Response, body []byte, err error) {
+	ctx := req.Context()
+	// the backoff sleep before next try, and the actual one
+	// which might be overridden by Retry-After
+	var backoff, sleep time.Duration
+	var attempts []Attempt
+	giveUp := func(reason, e error) error {
+		if stream && resp != nil && (reason != ErrMaxTries || e != nil) {
+			// the caller gets no body to close,
+			// but the final response on a status
+			discard(resp)
+			resp = nil
+		}
+		return &GiveUpError{Reason: reason, Tries: tries, Err: e, Attempts: attempts}
+	}
+
+	if c.budget != nil {
+		c.budget.deposit()
+	}
 
 	for tries = 1; tries <= maxTries; tries++ {
-		// backoff
-		time.Sleep(time.Duration(wait) * time.Millisecond)
+		// fail fast if no budget left for a retry
+		if tries > 1 && c.budget != nil && !c.budget.withdraw() {
+			tries--
+			err = giveUp(ErrRetryBudget, err)
+			return
+		}
+		// backoff, or give up if the context is done meanwhile
+		if e := sleepContext(ctx, sleep); e != nil {
+			tries--
+			err = giveUp(ErrContextDone, e)
+			return
+		}
+		slept := sleep
 		// update next sleep time
-		wait = c.bk.Next(wait)
+		backoff = c.bk.Sleep(tries, backoff)
+		sleep = backoff
 		// force reset Body if possible,
 		// to avoid error: http: ContentLength=n with Body length 0
 		if tries > 1 && req.Body != nil && req.GetBody != nil {
 			req.Body, _ = req.GetBody()
 		}
 		// do request
-		status, body, err = RequestWithClose(c.cl, req)
-		if err != nil {
-			if !c.timeoutOnly || IsTimeoutErr(err) {
-				continue
-			}
+		r, b, a, e := c.send(req, tries, slept, stream)
+		if e == ErrCircuitOpen {
+			// fail fast as the host is known down
+			tries--
+			err = giveUp(ErrCircuitOpen, err)
 			return
 		}
-		// no error, check status
-		if ShouldRetry(status) {
-			continue
+		if a.Start.IsZero() {
+			// waiting for the rate limiter until the context is done
+			tries--
+			err = giveUp(ErrContextDone, e)
+			return
+		}
+		resp, body, err = r, b, e
+		attempts = append(attempts, a)
+		if err != nil && ctx.Err() != nil {
+			// the attempt failed due to the context
+			err = giveUp(ErrContextDone, err)
+			return
+		}
+		if !retryable || !policy.Retry(tries, req, resp, err) {
+			// succeed or should not repeat
+			return
+		}
+		if err == nil && c.retryAfter {
+			sleep = c.retryAfterSleep(resp, sleep)
+		}
+		if stream && tries < maxTries {
+			// free the connection and slots before backoff
+			discard(resp)
+			resp = nil
 		}
-		// succeed or should not repeat
-		return
 	}
 
-	// return the last request's response, succeed or not
+	// the last try failed with an error or a status to retry
 	tries--
+	if len(attempts) > 0 {
+		err = giveUp(ErrMaxTries, err)
+	}
+	return
+}
+
+// newAttempt records a try started at start.
+func newAttempt(start time.Time, backoff time.Duration, resp *http.Response, err error) Attempt {
+	a := Attempt{
+		Start:    start,
+		Duration: time.Since(start),
+		Backoff:  backoff,
+		Err:      err,
+	}
+	if resp != nil {
+		a.Status = resp.StatusCode
+	}
+	return a
+}
+
+// send makes the attempt-th try of req after backoff, waiting for
+// the rate limiter, checking and updating the breaker and
+// taking a slot of the concurrency limiter if any,
+// and returns the record of it (zero if it is not made)
+// which is also reported to the observer if any;
+// it returns ErrCircuitOpen without trying if the breaker is open.
+// The response Body is left open for streaming,
+// or read (up to maxBody) and closed otherwise.
+func (c *client) send(req *http.Request, attempt int, backoff time.Duration, stream bool) (resp *http.Response, body []byte, a Attempt, err error) {
+	var mirror *mirror
+	if c.mirrors != nil {
+		req, mirror = c.mirrors.route(req, attempt)
+	} else if c.balancer != nil {
+		var (
+			ep    *endpoint
+			probe bool
+		)
+		req, ep, probe = c.balancer.route(req)
+		callCtx := req.Context()
+		defer func() {
+			if a.Start.IsZero() || err != nil && callCtx.Err() != nil {
+				// not sent or cancelled
+				c.balancer.cancel(ep, probe)
+			} else {
+				c.balancer.record(ep, probe, c.isFailure(resp, err))
+			}
+			if stream && resp != nil {
+				// in flight until the body is closed
+				resp.Body = &onCloseBody{ReadCloser: resp.Body, fn: func() { c.balancer.release(ep) }}
+			} else {
+				c.balancer.release(ep)
+			}
+		}()
+	}
+	host := req.URL.Host
+	parent := req.Context()
+	if c.limiter != nil {
+		if err = c.limiter.Wait(parent, host); err != nil {
+			return
+		}
+	}
+	if c.breaker != nil && !c.breaker.allow(host) {
+		err = ErrCircuitOpen
+		return
+	}
+	if c.conns != nil {
+		var release func()
+		if release, err = c.conns.acquire(parent, host); err != nil {
+			if c.breaker != nil {
+				c.breaker.release(host)
+			}
+			return
+		}
+		defer func() {
+			if stream && resp != nil {
+				// the slot is taken until the body is closed
+				resp.Body = &onCloseBody{ReadCloser: resp.Body, fn: release}
+			} else {
+				release()
+			}
+		}()
+	}
+	// for the middlewares
+	ctx := context.WithValue(parent, attemptKey{}, attempt)
+	var tt *traceTimer
+	if c.observer != nil {
+		tt = &traceTimer{}
+		ctx = httptrace.WithClientTrace(ctx, tt.clientTrace())
+	}
+	req = req.WithContext(ctx)
+	if c.attemptTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.attemptTimeout)
+		req = req.WithContext(ctx)
+		defer func() {
+			if stream && resp != nil {
+				// the timeout covers reading the body
+				resp.Body = &onCloseBody{ReadCloser: resp.Body, fn: cancel}
+			} else {
+				cancel()
+			}
+		}()
+	}
+	start := time.Now()
+	if stream {
+		resp, err = c.cl.Do(req)
+	} else {
+		resp, body, err = requestWithClose(c.cl, req, c.maxBody)
+	}
+	a = newAttempt(start, backoff, resp, err)
+	// cancelled, not the host's fault
+	cancelled := err != nil && parent.Err() != nil
+	if c.breaker != nil {
+		if cancelled {
+			c.breaker.release(host)
+		} else {
+			c.breaker.record(host, c.isFailure(resp, err))
+		}
+	}
+	if mirror != nil && !cancelled {
+		c.mirrors.record(mirror, c.isFailure(resp, err))
+	}
+	if c.observer != nil {
+		c.observer.ObserveAttempt(AttemptInfo{
+			Attempt: a,
+			Request: req,
+			Number:  attempt,
+			Phases:  tt.phases(start),
+		})
+	}
 	return
 }
 
+// onCloseBody calls fn once the Body of
+// a streamed response is closed, e.g., to cancel its context.
+type onCloseBody struct {
+	io.ReadCloser
+	fn   func()
+	once sync.Once
+}
+
+func (b *onCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.fn)
+	return err
+}
+
+// discard drains a bit of the response Body
+// for connection reuse, then closes it.
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+}
+
+// headerValues returns the values of the header key,
+// like http.Header.Values of go1.14.
+func headerValues(h http.Header, key string) []string {
+	return h[http.CanonicalHeaderKey(key)]
+}
+
+// canReplay tells if req can be sent again,
+// i.e., it has no body or a way to get a new copy.
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isFailure tells if an attempt failed by the rules of
+// ShouldRetry and IsTimeoutErr, i.e., any error (timeouts only
+// if TimeoutOnly) or should-retry status code; ErrBodyTooLarge
+// is not the host's failure.
+func (c *client) isFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			return false
+		}
+		return !c.timeoutOnly || IsTimeoutErr(err)
+	}
+	return ShouldRetry(resp.StatusCode)
+}
+
+// retryPolicy returns the custom RetryPolicy if set,
+// or the DefaultPolicy following the client settings.
+func (c *client) retryPolicy() RetryPolicy {
+	if c.policy != nil {
+		return c.policy
+	}
+	return DefaultPolicy{
+		TimeoutOnly:     c.timeoutOnly,
+		TooManyRequests: c.retryAfter,
+	}
+}
+
+// retryAfterSleep returns the server-given delay of a 429 or 503
+// response capped by the backoff Cap, or the backoff sleep otherwise.
+func (c *client) retryAfterSleep(resp *http.Response, sleep time.Duration) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable {
+		return sleep
+	}
+	d, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return sleep
+	}
+	if limit := c.bk.Cap(); d > limit {
+		return limit
+	}
+	return d
+}
+
 // ClientOption allows functional pattern options for client.
 type ClientOption func(*client)
 
@@ -165,6 +692,166 @@ func TimeoutOnly() ClientOption {
 	}
 }
 
+// RespectRetryAfter sets the client to retry 429 as well,
+// and to wait for the Retry-After delay of 429 and 503
+// responses (capped by the backoff Cap) instead of backoff.
+func RespectRetryAfter() ClientOption {
+	return func(c *client) {
+		c.retryAfter = true
+	}
+}
+
+// RetryAnyMethod sets the client to retry requests
+// regardless of whether they are idempotent.
+func RetryAnyMethod() ClientOption {
+	return func(c *client) {
+		c.anyMethod = true
+	}
+}
+
+// WithIdempotencyKey sets the client to attach a generated
+// Idempotency-Key header to non-idempotent requests without one,
+// which makes them retryable; the key is reused on every try.
+func WithIdempotencyKey() ClientOption {
+	return func(c *client) {
+		c.genKey = true
+	}
+}
+
+// WithRetryPolicy substitutes the DefaultPolicy
+// (TimeoutOnly no longer takes effect then).
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *client) {
+		c.policy = p
+	}
+}
+
+// WithBreaker sets a circuit breaker (which may be shared
+// among clients) that fails requests fast while their host's
+// breaker is open.
+func WithBreaker(b *Breaker) ClientOption {
+	return func(c *client) {
+		c.breaker = b
+	}
+}
+
+// WithHedging sets the client to hedge idempotent requests:
+// another concurrent try is started if no response arrives
+// within the hedging delay, and the first good one wins.
+func WithHedging(h *Hedging) ClientOption {
+	return func(c *client) {
+		c.hedging = h
+	}
+}
+
+// WithMaxBodySize limits the response body size Do buffers
+// to n bytes; ErrBodyTooLarge is reported if it exceeds.
+func WithMaxBodySize(n int64) ClientOption {
+	return func(c *client) {
+		c.maxBody = n
+	}
+}
+
+// WithAttemptTimeout limits each try (reading the body included)
+// to d with a context derived from the request's, so that
+// a slow try does not consume the whole deadline;
+// the shorter of it and http.Client.Timeout takes effect.
+func WithAttemptTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.attemptTimeout = d
+	}
+}
+
+// WithTotalTimeout limits each call of Do or Stream, i.e.,
+// all tries and backoff in between (reading the streamed body
+// included), to d as the hard upper bound.
+func WithTotalTimeout(d time.Duration) ClientOption {
+	return func(c *client) {
+		c.totalTimeout = d
+	}
+}
+
+// WithRetryBudget sets a retry budget shared by
+// all calls on the client; once it is spent,
+// failures are returned without retry.
+func WithRetryBudget(b *RetryBudget) ClientOption {
+	return func(c *client) {
+		c.budget = b
+	}
+}
+
+// WithMiddleware appends middlewares to the client's chain,
+// the first one being the outermost; they wrap the Transport
+// of the http.Client in use and see every try.
+func WithMiddleware(mws ...Middleware) ClientOption {
+	return func(c *client) {
+		c.mws = append(c.mws, mws...)
+	}
+}
+
+// WithObserver sets the Observer notified of every try.
+func WithObserver(o Observer) ClientOption {
+	return func(c *client) {
+		c.observer = o
+	}
+}
+
+// WithRateLimiter sets the RateLimiter every try waits for,
+// respecting the request context.
+func WithRateLimiter(l *RateLimiter) ClientOption {
+	return func(c *client) {
+		c.limiter = l
+	}
+}
+
+// WithConcurrencyLimiter caps the tries in flight; each try
+// takes a slot with the request context before it starts and
+// gives it back once done (the body closed for Stream).
+func WithConcurrencyLimiter(l *ConcurrencyLimiter) ClientOption {
+	return func(c *client) {
+		c.conns = l
+	}
+}
+
+// WithCoalescing sets the client to collapse concurrent
+// identical GET and HEAD requests without body, i.e., the same
+// method, URL, credentials (Authorization and Cookie headers)
+// and the given headers, into a single call of Do
+// and share its result with every caller.
+// A caller giving up does not affect others, while
+// the shared call is cancelled if all of them give up.
+func WithCoalescing(headers ...string) ClientOption {
+	return func(c *client) {
+		c.flights = &flightGroup{headers: headers}
+	}
+}
+
+// WithCache caches responses to GET requests in s per RFC 7234
+// (see CacheTransport), under the middlewares; when the client
+// gives up on a request, with an error or a status to retry,
+// it serves the stored response allowed by stale-if-error.
+func WithCache(s CacheStorage) ClientOption {
+	return func(c *client) {
+		c.cache = &CacheTransport{Storage: s}
+	}
+}
+
+// WithMirrors sends the requests to the Mirrors,
+// each try to the next one.
+func WithMirrors(m *Mirrors) ClientOption {
+	return func(c *client) {
+		c.mirrors = m
+	}
+}
+
+// WithBalancer spreads the tries over the endpoints
+// of the Balancer; it is ignored with WithMirrors.
+func WithBalancer(b *Balancer) ClientOption {
+	return func(c *client) {
+		c.balancer = b
+	}
+}
+
 // WithHTTPClient substitutes the default 5s
 // timeout http.Client with a custom one.
 func WithHTTPClient(cl *http.Client) ClientOption {
@@ -173,15 +860,16 @@ func WithHTTPClient(cl *http.Client) ClientOption {
 	}
 }
 
-// WithBackoff substitutes the default Backoff.
-func WithBackoff(b Backoff) ClientOption {
+// WithBackoff substitutes the default Backoff
+// with any BackoffStrategy.
+func WithBackoff(b BackoffStrategy) ClientOption {
 	return func(c *client) {
 		c.bk = b
 	}
 }
 
 // NewClient returns a client with default setting:
-// 1. retry on all errors;
+// 1. retry on all errors, for idempotent requests only;
 // 2. http.Client set Timeout to 5s;
 // 3. Backoff{100, 5000}.
 func NewClient(ops ...ClientOption) Client {
@@ -195,5 +883,17 @@ func NewClient(ops ...ClientOption) Client {
 		op(c)
 	}
 
+	if c.cache != nil || len(c.mws) > 0 {
+		// never modify the given http.Client
+		cl := *c.cl
+		rt := cl.Transport
+		if c.cache != nil {
+			c.cache.Transport = rt
+			rt = c.cache
+		}
+		cl.Transport = Chain(rt, c.mws...)
+		c.cl = &cl
+	}
+
 	return c
 }
@@ -2,12 +2,15 @@ package web_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -110,7 +113,11 @@ func TestRequestWithClose(t *testing.T) {
 			a.NotNil(err, test.desp+"should return timeout error")
 			a.Equal(true, web.IsTimeoutErr(err), test.desp+err.Error()+" should be a timeout")
 		} else {
-			a.NoError(err, test.desp+"request succeeds")
+			if web.ShouldRetry(test.expectedCode) {
+				a.True(gaveUpOnStatus(err), test.desp+"should run out of tries")
+			} else {
+				a.NoError(err, test.desp+"request succeeds")
+			}
 			a.Equal(test.expectedCode, status, test.desp+"check code")
 			a.Equal(test.expectedBody, body, test.desp+"check body")
 		}
@@ -185,6 +192,13 @@ type retryTest struct {
 	tries    int
 }
 
+// gaveUpOnStatus tells if err is a *GiveUpError
+// out of tries on a status to retry.
+func gaveUpOnStatus(err error) bool {
+	var ge *web.GiveUpError
+	return errors.As(err, &ge) && ge.Err == nil && errors.Is(err, web.ErrMaxTries)
+}
+
 func TestClientDo(t *testing.T) {
 	a := assert.New(t)
 
@@ -245,12 +259,19 @@ func TestClientDo(t *testing.T) {
 			t.Errorf("Error new request: %s", err)
 			continue
 		}
+		// POST is retried only with the key
+		req.Header.Set(web.IdempotencyKeyHeader, "test")
 		n, status, body, err := cl.Do(req, test.maxTries)
 		if test.expectTimeout {
 			a.NotNil(err, test.desp+"should return timeout error")
 			a.Equal(true, web.IsTimeoutErr(err), test.desp+err.Error()+" should be a timeout")
+			a.True(errors.Is(err, web.ErrMaxTries), test.desp+"should run out of tries")
 		} else {
-			a.NoError(err, test.desp+"request succeeds")
+			if web.ShouldRetry(test.expectedCode) {
+				a.True(gaveUpOnStatus(err), test.desp+"should run out of tries")
+			} else {
+				a.NoError(err, test.desp+"request succeeds")
+			}
 			a.Equal(test.expectedCode, status, test.desp+"check code")
 			a.Equal(test.expectedBody, body, test.desp+"check body")
 		}
@@ -260,4 +281,326 @@ func TestClientDo(t *testing.T) {
 	}
 }
 
+func TestClientDo_Context(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	// long backoff that outlives the deadline
+	cl := web.NewClient(web.WithBackoff(web.Backoff{BaseSleep: 1000, MaxSleep: 5000}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	n, status, _, err := cl.Do(req, 5)
+	a.True(time.Since(start) < time.Second, "Should stop during backoff")
+	a.Equal(1, n, "Only the first try is made")
+	a.Equal(http.StatusBadGateway, status, "Status of the last try")
+	a.True(errors.Is(err, web.ErrContextDone), "Context runs out first")
+	a.True(errors.Is(err, context.DeadlineExceeded), "Wraps the context error")
+	a.True(!errors.Is(err, web.ErrMaxTries), "Tries are not used up")
+
+	// cancelled before any try
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	n, _, _, err = cl.Do(req.WithContext(ctx), 5)
+	a.Equal(0, n, "No try is made")
+	a.True(errors.Is(err, context.Canceled), "Cancelled")
+}
+
 // TODO: cases for web.TimeoutOnly web.WithBackoff
+
+func TestParseRetryAfter(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		d     time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 01 Jan 2018 00:00:10 GMT", 10 * time.Second, true},
+		{"Sun, 31 Dec 2017 23:59:00 GMT", 0, true},
+	}
+	for _, test := range tests {
+		d, ok := web.ParseRetryAfter(test.value, now)
+		a.Equal(test.ok, ok, "Parse "+test.value)
+		a.Equal(test.d, d, "Delay of "+test.value)
+	}
+}
+
+// FlakyHandler responds with code (and Retry-After if given)
+// for the first n requests, then 200 OK.
+func FlakyHandler(n, code int, retryAfter string) http.HandlerFunc {
+	var count int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&count, 1)) <= n {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(okResp)
+	}
+}
+
+func TestClientDo_RetryAfter(t *testing.T) {
+	a := assert.New(t)
+
+	bk := web.Backoff{BaseSleep: 1, MaxSleep: 50}
+
+	server := httptest.NewServer(FlakyHandler(1, http.StatusTooManyRequests, "10"))
+	n, status, _, err := web.NewClient(web.WithBackoff(bk)).Do(newGet(t, server.URL), 3)
+	a.NoError(err, "No error for 429")
+	a.Equal(1, n, "429 is not retried by default")
+	a.Equal(http.StatusTooManyRequests, status, "Got 429")
+	server.Close()
+
+	cl := web.NewClient(web.WithBackoff(bk), web.RespectRetryAfter())
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		server = httptest.NewServer(FlakyHandler(1, code, "10"))
+		start := time.Now()
+		n, status, _, err = cl.Do(newGet(t, server.URL), 3)
+		elapsed := time.Since(start)
+		a.NoError(err, "Succeed after retry")
+		a.Equal(2, n, "Retried once")
+		a.Equal(http.StatusOK, status, "Got 200")
+		a.True(elapsed >= 50*time.Millisecond, "Waits for Retry-After")
+		a.True(elapsed < time.Second, "Retry-After is capped by MaxSleep")
+		server.Close()
+	}
+}
+
+func TestClientDo_Idempotency(t *testing.T) {
+	a := assert.New(t)
+
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(web.IdempotencyKeyHeader))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	bk := web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2})
+	newPost := func() *http.Request {
+		req, err := web.NewJSONPost(server.URL, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	n, _, _, _ := web.NewClient(bk).Do(newPost(), 3)
+	a.Equal(1, n, "POST is not retried by default")
+
+	n, _, _, _ = web.NewClient(bk, web.RetryAnyMethod()).Do(newPost(), 3)
+	a.Equal(3, n, "POST is retried with RetryAnyMethod")
+
+	keys = nil
+	n, _, _, _ = web.NewClient(bk, web.WithIdempotencyKey()).Do(newPost(), 3)
+	a.Equal(3, n, "POST is retried with generated key")
+	a.Equal(3, len(keys), "3 requests received")
+	a.True(keys[0] != "", "Key is attached")
+	a.True(keys[0] == keys[1] && keys[1] == keys[2], "Same key on every try")
+
+	keys = nil
+	req := newPost()
+	req.Header.Set(web.IdempotencyKeyHeader, "mine")
+	web.NewClient(bk, web.WithIdempotencyKey()).Do(req, 2)
+	a.Equal([]string{"mine", "mine"}, keys, "Keep the given key")
+
+	keys = nil
+	web.NewClient(bk, web.WithIdempotencyKey()).Do(newGet(t, server.URL), 2)
+	a.Equal([]string{"", ""}, keys, "No key for GET")
+}
+
+func TestNewIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	k1, err := web.NewIdempotencyKey()
+	a.NoError(err, "Generate key")
+	k2, _ := web.NewIdempotencyKey()
+	a.Equal(36, len(k1), "UUID format")
+	a.True(k1 != k2, "Random keys")
+}
+
+func newGet(t *testing.T, url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestClientStream(t *testing.T) {
+	a := assert.New(t)
+
+	large := bytes.Repeat([]byte("0123456789"), 1<<10)
+	server := httptest.NewServer(FlakyHandler(2, http.StatusBadGateway, ""))
+	defer server.Close()
+	bigServer := httptest.NewServer(DummyHandler(http.StatusOK, large))
+	defer bigServer.Close()
+
+	cl := web.NewClient(web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}))
+
+	n, resp, err := cl.Stream(newGet(t, server.URL), 3)
+	a.NoError(err, "Stream succeeds")
+	a.Equal(3, n, "Retried before the body")
+	a.Equal(http.StatusOK, resp.StatusCode, "Got 200")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.NoError(err, "Read the live body")
+	a.Equal(okResp, body, "Got body")
+
+	n, resp, err = cl.Stream(newGet(t, bigServer.URL), 3)
+	a.NoError(err, "Stream succeeds")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Equal(len(large), len(body), "Stream is not limited")
+
+	cl = web.NewClient(web.WithMaxBodySize(int64(len(large) - 1)))
+	n, status, body, err := cl.Do(newGet(t, bigServer.URL), 3)
+	a.True(errors.Is(err, web.ErrBodyTooLarge), "Exceeds max body size")
+	a.Equal(1, n, "Not retried")
+	a.Equal(http.StatusOK, status, "Got 200")
+	a.Equal(0, len(body), "Body dropped")
+
+	cl = web.NewClient(web.WithMaxBodySize(int64(len(large))))
+	_, _, body, err = cl.Do(newGet(t, bigServer.URL), 3)
+	a.NoError(err, "Within max body size")
+	a.Equal(large, body, "Got body")
+}
+
+func TestClientDo_Timeouts(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(SlowFirstHandler(time.Second))
+	defer server.Close()
+
+	bk := web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2})
+	cl := web.NewClient(bk, web.WithAttemptTimeout(30*time.Millisecond))
+	start := time.Now()
+	n, status, _, err := cl.Do(newGet(t, server.URL), 3)
+	a.True(time.Since(start) < 500*time.Millisecond, "Slow try is cut")
+	a.NoError(err, "Second try succeeds")
+	a.Equal(2, n, "Retried after attempt timeout")
+	a.Equal(http.StatusOK, status, "Got 200")
+
+	slow := httptest.NewServer(SleepHandler(time.Second, false))
+	defer slow.Close()
+
+	cl = web.NewClient(bk,
+		web.WithAttemptTimeout(30*time.Millisecond),
+		web.WithTotalTimeout(100*time.Millisecond),
+	)
+	start = time.Now()
+	n, _, _, err = cl.Do(newGet(t, slow.URL), 100)
+	a.True(time.Since(start) < 500*time.Millisecond, "Bounded by total timeout")
+	a.True(n > 1 && n < 100, "Some tries made")
+	a.True(errors.Is(err, web.ErrContextDone), "Deadline runs out first")
+	a.True(web.IsTimeoutErr(err), "Still a timeout")
+
+	// the total timeout covers the streamed body
+	cl = web.NewClient(web.WithTotalTimeout(time.Second))
+	_, resp, err := cl.Stream(newGet(t, server.URL), 1)
+	a.NoError(err, "Stream succeeds")
+	_, err = ioutil.ReadAll(resp.Body)
+	a.NoError(err, "Body is readable")
+	a.NoError(resp.Body.Close(), "Close")
+}
+
+func TestGiveUpError_Attempts(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// the last one times out
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(
+		web.WithHTTPClient(&http.Client{Timeout: 10 * time.Millisecond}),
+		web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}),
+	)
+	begin := time.Now()
+	n, _, _, err := cl.Do(newGet(t, server.URL), 3)
+	a.Equal(3, n, "All tries made")
+
+	var ge *web.GiveUpError
+	a.True(errors.As(err, &ge), "Typed error")
+	a.True(web.IsTimeoutErr(err), "Still a timeout")
+	a.Equal(web.ErrMaxTries, ge.Reason, "Reason to give up")
+	a.Equal(3, len(ge.Attempts), "Every try recorded")
+	a.Equal(http.StatusBadGateway, ge.Attempts[0].Status, "#1 got 502")
+	a.Equal(http.StatusBadGateway, ge.Attempts[1].Status, "#2 got 502")
+	a.True(web.IsTimeoutErr(ge.Attempts[2].Err), "#3 timed out")
+	a.Equal(time.Duration(0), ge.Attempts[0].Backoff, "No backoff before #1")
+	a.True(ge.Attempts[1].Backoff > 0, "Backoff before #2")
+	a.True(!ge.Attempts[0].Start.Before(begin), "Start recorded")
+	a.True(ge.Attempts[2].Duration >= 10*time.Millisecond, "Duration recorded")
+}
+
+func TestClientStream_GiveUpCloses(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusServiceUnavailable, errResp))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	l := web.NewConcurrencyLimiter(1, false)
+	cl := web.NewClient(web.WithConcurrencyLimiter(l), web.WithBackoff(web.ConstantBackoff{Delay: time.Second}))
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		_, resp, err := cl.Stream(newGet(t, server.URL).WithContext(ctx), 10)
+		cancel()
+		a.True(errors.Is(err, web.ErrContextDone), "Gives up in backoff")
+		a.True(resp == nil, "No response")
+		a.Equal(0, l.InFlight(host), "Slot released")
+	}
+}
+
+func TestGiveUpError_Status(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithBackoff(web.ConstantBackoff{}))
+	n, status, body, err := cl.Do(newGet(t, server.URL), 3)
+	a.Equal(3, n, "All tries made")
+	a.Equal(http.StatusBadGateway, status, "Status of the last try")
+	a.Equal(errResp, body, "Body of the last try")
+
+	var ge *web.GiveUpError
+	a.True(errors.As(err, &ge), "Typed error")
+	a.Equal(web.ErrMaxTries, ge.Reason, "Out of tries")
+	a.True(ge.Err == nil, "No underlying error")
+	a.Equal(3, len(ge.Attempts), "Every try recorded")
+	a.Equal(http.StatusBadGateway, ge.Attempts[2].Status, "#3 got 502")
+
+	n, resp, err := cl.Stream(newGet(t, server.URL), 2)
+	a.Equal(2, n, "All tries streamed")
+	a.True(errors.As(err, &ge), "Typed error for Stream")
+	a.True(ge.Err == nil, "No underlying error for Stream")
+	a.Equal(http.StatusBadGateway, resp.StatusCode, "Final response")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Equal(errResp, body, "Body of the final response")
+}
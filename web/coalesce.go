@@ -0,0 +1,127 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// detachedContext keeps the values of a context
+// but not its cancellation or deadline.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// flightCall is an in-flight (or finished) shared call.
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int // guarded by flightGroup.mu
+
+	tries, status int
+	body          []byte
+	err           error
+}
+
+// flightGroup coalesces identical requests into one call.
+type flightGroup struct {
+	headers []string
+
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// canCoalesce tells if req is a GET or HEAD without body.
+func canCoalesce(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD":
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// credentialHeaders are always in the key so that
+// callers never get a response made for others.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// key identifies identical requests.
+func (g *flightGroup) key(req *http.Request) string {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteString(" ")
+	b.WriteString(req.URL.String())
+	for _, hs := range [][]string{credentialHeaders, g.headers} {
+		for _, h := range hs {
+			b.WriteString("\n")
+			b.WriteString(http.CanonicalHeaderKey(h))
+			b.WriteString(": ")
+			b.WriteString(strings.Join(headerValues(req.Header, h), ", "))
+		}
+	}
+	return b.String()
+}
+
+// do joins the call for req or starts one in the background,
+// then waits for it or for the request context to be done.
+func (g *flightGroup) do(c *client, req *http.Request, maxTries int) (tries, status int, body []byte, err error) {
+	key := g.key(req)
+
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(detachedContext{req.Context()})
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(c, call, key, req.WithContext(ctx), maxTries)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		// every caller gets its own copy of body
+		if call.body != nil {
+			body = append([]byte(nil), call.body...)
+		}
+		return call.tries, call.status, body, call.err
+	case <-req.Context().Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody waits for it any more
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		err = &GiveUpError{Reason: ErrContextDone, Err: req.Context().Err()}
+		return
+	}
+}
+
+func (g *flightGroup) run(c *client, call *flightCall, key string, req *http.Request, maxTries int) {
+	call.tries, call.status, call.body, call.err = c.do(req, maxTries)
+	call.cancel()
+
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+
+	close(call.done)
+}
@@ -0,0 +1,116 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestClientDo_Coalescing(t *testing.T) {
+	a := assert.New(t)
+
+	var count, cancelled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		select {
+		case <-time.After(50 * time.Millisecond):
+			w.Write([]byte(r.Header.Get("Accept")))
+		case <-r.Context().Done():
+			atomic.AddInt32(&cancelled, 1)
+		}
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithCoalescing("Accept"))
+	get := func(ctx context.Context, accept string) (int, []byte, error) {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Accept", accept)
+		_, status, body, err := cl.Do(req.WithContext(ctx), 1)
+		return status, body, err
+	}
+
+	var wg sync.WaitGroup
+	var ok int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, body, err := get(context.Background(), "text/plain")
+			if err == nil && status == http.StatusOK && string(body) == "text/plain" {
+				atomic.AddInt32(&ok, 1)
+			}
+		}()
+	}
+	// a caller giving up
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, _, err := get(ctx, "text/plain")
+		a.True(errors.Is(err, web.ErrContextDone), "Gives up alone")
+	}()
+	// different header
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, body, _ := get(context.Background(), "application/json")
+		a.Equal("application/json", string(body), "Not coalesced")
+	}()
+	wg.Wait()
+
+	a.Equal(int32(5), atomic.LoadInt32(&ok), "Every caller gets the result")
+	a.Equal(int32(2), atomic.LoadInt32(&count), "One call per header value")
+	a.Equal(int32(0), atomic.LoadInt32(&cancelled), "The shared call is not cancelled")
+
+	// all callers give up
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			get(ctx, "text/html")
+		}()
+	}
+	wg.Wait()
+	time.Sleep(20 * time.Millisecond)
+	a.Equal(int32(3), atomic.LoadInt32(&count), "One more call")
+	a.Equal(int32(1), atomic.LoadInt32(&cancelled), "The shared call is cancelled")
+}
+
+func TestClientDo_CoalescingCredentials(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithCoalescing())
+	var wg sync.WaitGroup
+	for _, token := range []string{"Bearer alice", "Bearer bob"} {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			req.Header.Set("Authorization", token)
+			_, _, body, err := cl.Do(req, 1)
+			a.NoError(err, "Succeed")
+			a.Equal(token, string(body), "Own response")
+		}(token)
+	}
+	wg.Wait()
+	a.Equal(int32(2), atomic.LoadInt32(&count), "Not coalesced")
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ShevaXu/golang/semaphore"
+)
+
+// ConcurrencyLimiter caps the tries in flight of a client,
+// as a whole or per host, with semaphore.Semaphore
+// (safe for concurrent use by multiple goroutines).
+type ConcurrencyLimiter struct {
+	Limit   int
+	PerHost bool
+	// OnQueue, if not nil, is called with the time
+	// each try waits for a slot.
+	OnQueue func(host string, wait time.Duration)
+
+	mu   sync.Mutex
+	sems map[string]semaphore.Semaphore
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter
+// allowing limit tries in flight (per host if perHost).
+func NewConcurrencyLimiter(limit int, perHost bool) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{Limit: limit, PerHost: perHost}
+}
+
+// InFlight returns the tries in flight to the host
+// (or all hosts if not PerHost).
+func (l *ConcurrencyLimiter) InFlight(host string) int {
+	return l.semaphore(host).Count()
+}
+
+func (l *ConcurrencyLimiter) semaphore(host string) semaphore.Semaphore {
+	if !l.PerHost {
+		host = ""
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.sems == nil {
+		l.sems = make(map[string]semaphore.Semaphore)
+	}
+	s, ok := l.sems[host]
+	if !ok {
+		s = semaphore.New(l.Limit)
+		l.sems[host] = s
+	}
+	return s
+}
+
+// acquire obtains a slot for the host, returning ctx.Err()
+// if ctx is done before; release must be called once
+// the try is done.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, host string) (release func(), err error) {
+	s := l.semaphore(host)
+	start := time.Now()
+	if !s.Obtain(ctx) {
+		return nil, ctx.Err()
+	}
+	if l.OnQueue != nil {
+		l.OnQueue(host, time.Since(start))
+	}
+	return func() { s.Release() }, nil
+}
@@ -0,0 +1,110 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestClientDo_ConcurrencyLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	var cur, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&cur, -1)
+	}))
+	defer server.Close()
+
+	var (
+		mu     sync.Mutex
+		queued []time.Duration
+	)
+	l := web.NewConcurrencyLimiter(2, true)
+	l.OnQueue = func(host string, wait time.Duration) {
+		mu.Lock()
+		queued = append(queued, wait)
+		mu.Unlock()
+	}
+	cl := web.NewClient(web.WithConcurrencyLimiter(l))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			cl.Do(req, 1)
+		}()
+	}
+	wg.Wait()
+
+	a.Equal(int32(2), atomic.LoadInt32(&peak), "At most 2 in flight")
+	a.Equal(6, len(queued), "Queueing time reported")
+	var longest time.Duration
+	for _, d := range queued {
+		if d > longest {
+			longest = d
+		}
+	}
+	a.True(longest >= 20*time.Millisecond, "Some tries queued")
+
+	// the slot is held until the streamed body is closed
+	l = web.NewConcurrencyLimiter(1, false)
+	cl = web.NewClient(web.WithConcurrencyLimiter(l))
+	_, resp, err := cl.Stream(newGet(t, server.URL), 1)
+	a.NoError(err, "Stream")
+	a.Equal(1, l.InFlight(""), "Slot taken")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n, _, _, err := cl.Do(newGet(t, server.URL).WithContext(ctx), 1)
+	a.Equal(0, n, "No try made")
+	a.True(errors.Is(err, web.ErrContextDone), "Context done queueing")
+
+	resp.Body.Close()
+	resp.Body.Close()
+	a.Equal(0, l.InFlight(""), "Slot released once")
+}
+
+func TestClientStream_ConcurrencyLimiterBackoff(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	l := web.NewConcurrencyLimiter(1, true)
+	cl := web.NewClient(
+		web.WithConcurrencyLimiter(l),
+		web.WithBackoff(web.ConstantBackoff{Delay: 300 * time.Millisecond}),
+	)
+	host := newGet(t, server.URL).URL.Host
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, resp, _ := cl.Stream(newGet(t, server.URL), 2)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(150 * time.Millisecond)
+	a.Equal(0, l.InFlight(host), "Slot released during backoff")
+	<-done
+	a.Equal(0, l.InFlight(host), "Slot released")
+}
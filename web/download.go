@@ -0,0 +1,391 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// file suffixes of a download in progress
+const (
+	partSuffix = ".part"
+	metaSuffix = ".part.meta" // the validator of the part
+)
+
+// ErrIdleTimeout is reported when a download body
+// reads nothing for the Downloader's IdleTimeout.
+var ErrIdleTimeout = errors.New("web: download idle timeout")
+
+// downloadTransport is the Transport of the default
+// download client, which times out waiting for headers.
+var downloadTransport = func() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return t
+}()
+
+// Downloader downloads files resumably
+// (safe for concurrent use by multiple goroutines
+// for different files).
+// The content goes to a temporary file next to the
+// target, renamed once complete; an interrupted download
+// resumes from it with a Range request, which the If-Range
+// validator (ETag or Last-Modified) restarts if the
+// content has changed meanwhile.
+type Downloader struct {
+	// Client makes the requests, retrying each of them; if nil,
+	// one timing out after 30s without response headers,
+	// and no timeout on the whole body (see IdleTimeout).
+	Client Client
+	// IdleTimeout drops, then resumes, a body reading
+	// nothing for so long; 30s if <= 0.
+	IdleTimeout time.Duration
+	// MaxTries for each request, and of requests in a row
+	// making no progress; 3 if <= 0.
+	MaxTries int
+	// Backoff before resuming an interrupted body,
+	// the same default as Client if nil.
+	Backoff BackoffStrategy
+	// Workers, if > 1, downloads files over ChunkSize in
+	// byte-range chunks in parallel if the server accepts
+	// ranges; no part is left if it fails.
+	Workers int
+	// ChunkSize of parallel downloads, 8MB if <= 0.
+	ChunkSize int64
+	// Digests to verify, besides those in the Content-MD5
+	// or Digest response headers; the data is hashed while
+	// streaming, or once assembled for parallel downloads.
+	// A mismatch deletes the part and returns *ChecksumError.
+	Digests []Digest
+	// BandwidthLimit caps the bytes read per second,
+	// chunks together; no limit if <= 0.
+	BandwidthLimit int64
+	// OnProgress, if not nil, is called with the Progress
+	// every ProgressInterval (1s if <= 0) while reading,
+	// and once complete.
+	OnProgress       func(Progress)
+	ProgressInterval time.Duration
+}
+
+// NewDownloader returns a Downloader using cl.
+func NewDownloader(cl Client, maxTries int) *Downloader {
+	return &Downloader{Client: cl, MaxTries: maxTries}
+}
+
+func (d *Downloader) client() Client {
+	if d.Client == nil {
+		return NewClient(WithHTTPClient(&http.Client{Transport: downloadTransport}))
+	}
+	return d.Client
+}
+
+func (d *Downloader) maxTries() int {
+	if d.MaxTries <= 0 {
+		return 3
+	}
+	return d.MaxTries
+}
+
+func (d *Downloader) backoff() BackoffStrategy {
+	if d.Backoff == nil {
+		return Backoff{100, 5000}
+	}
+	return d.Backoff
+}
+
+func (d *Downloader) chunkSize() int64 {
+	if d.ChunkSize <= 0 {
+		return 8 << 20
+	}
+	return d.ChunkSize
+}
+
+func (d *Downloader) idleTimeout() time.Duration {
+	if d.IdleTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return d.IdleTimeout
+}
+
+// idleReader closes the body if no Read
+// returns within the timeout.
+type idleReader struct {
+	rc      io.ReadCloser
+	idle    time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+// watch returns rc closed if idle for d.IdleTimeout.
+func (d *Downloader) watch(rc io.ReadCloser) io.ReadCloser {
+	r := &idleReader{rc: rc, idle: d.idleTimeout()}
+	r.timer = time.AfterFunc(r.idle, func() {
+		atomic.StoreInt32(&r.expired, 1)
+		rc.Close()
+	})
+	return r
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if atomic.LoadInt32(&r.expired) == 1 {
+		return n, ErrIdleTimeout
+	}
+	r.timer.Reset(r.idle)
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	return r.rc.Close()
+}
+
+// resumer paces the requests resuming interrupted bodies.
+type resumer struct {
+	failures int // in a row, making no progress
+	sleep    time.Duration
+}
+
+// wait waits before resuming, telling if to go on.
+func (r *resumer) wait(ctx context.Context, d *Downloader, progressed bool) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if progressed {
+		r.failures, r.sleep = 0, 0
+	}
+	r.failures++
+	if r.failures >= d.maxTries() {
+		return false
+	}
+	r.sleep = d.backoff().Sleep(r.failures, r.sleep)
+	return sleepContext(ctx, r.sleep) == nil
+}
+
+// Download downloads url to file.
+func (d *Downloader) Download(ctx context.Context, url, file string) error {
+	cl := d.client()
+	v, err := newVerifier(d.Digests)
+	if err != nil {
+		return err
+	}
+	m := d.newMeter()
+
+	chunked := false
+	if d.Workers > 1 {
+		// resume a part left by a plain download instead
+		if _, serr := os.Stat(file + partSuffix); os.IsNotExist(serr) {
+			size, h, ok := probeRanges(ctx, cl, url)
+			if chunked = ok && size > d.chunkSize(); chunked {
+				v.expectHeader(h, true)
+				m.reset(0, size)
+				err = d.downloadChunks(ctx, cl, url, file, size, strongValidator(h), v, m)
+			}
+		}
+	}
+	if !chunked {
+		err = d.download(ctx, cl, url, file, v, m)
+	}
+	if err == nil {
+		m.finish()
+	}
+	return err
+}
+
+// download downloads url to file in a single stream.
+func (d *Downloader) download(ctx context.Context, cl Client, url, file string, v *verifier, m *meter) error {
+	part, meta := file+partSuffix, file+metaSuffix
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var (
+		offset    int64
+		validator string
+		r         resumer
+		started   bool // hashing
+	)
+	if v, err := ioutil.ReadFile(meta); err == nil && len(v) > 0 {
+		if fi, err := out.Stat(); err == nil {
+			offset, validator = fi.Size(), string(v)
+		}
+	}
+	high := offset // the furthest offset reached
+
+	for {
+		if validator == "" {
+			// cannot resume safely
+			offset = 0
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		}
+
+		tries, resp, err := cl.Stream(req.WithContext(ctx), d.maxTries())
+		if err != nil {
+			discard(resp)
+			return err
+		}
+
+		var total int64 = -1 // unknown
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// from the start, changed or no range support
+			offset = 0
+			total = resp.ContentLength
+		case http.StatusPartialContent:
+			start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset {
+				// unexpected, start over
+				discard(resp)
+				offset, validator = 0, ""
+				if !r.wait(ctx, d, false) {
+					return &StatusError{Status: resp.StatusCode, Tries: tries}
+				}
+				continue
+			}
+			total = size
+		case http.StatusRequestedRangeNotSatisfiable:
+			discard(resp)
+			if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+				// complete already
+				if err = v.restart(part, offset); err != nil {
+					return err
+				}
+				return finishDownload(out, part, meta, file, v)
+			}
+			if offset == 0 || !r.wait(ctx, d, false) {
+				return &StatusError{Status: resp.StatusCode, Tries: tries}
+			}
+			offset, validator = 0, ""
+			continue
+		default:
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+			discard(resp)
+			return &StatusError{Status: resp.StatusCode, Body: body, Tries: tries}
+		}
+
+		if offset == 0 {
+			validator = strongValidator(resp.Header)
+			if err = out.Truncate(0); err == nil {
+				err = ioutil.WriteFile(meta, []byte(validator), 0644)
+			}
+			if err != nil {
+				discard(resp)
+				return err
+			}
+		}
+		if _, err = out.Seek(offset, io.SeekStart); err != nil {
+			discard(resp)
+			return err
+		}
+		if !started {
+			// later responses are of the same content
+			v.expectHeader(resp.Header, resp.StatusCode == http.StatusOK)
+		}
+		if !started || offset == 0 {
+			if err = v.restart(part, offset); err != nil {
+				discard(resp)
+				return err
+			}
+		}
+		started = true
+		m.reset(offset, total)
+
+		body := d.watch(resp.Body)
+		n, err := io.Copy(io.MultiWriter(out, v), m.reader(ctx, body))
+		body.Close()
+		offset += n
+		if err == nil && (total < 0 || offset == total) {
+			return finishDownload(out, part, meta, file, v)
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		// progress only past the furthest offset,
+		// not again after starting over
+		progressed := offset > high
+		if progressed {
+			high = offset
+		}
+		if !r.wait(ctx, d, progressed) {
+			return err
+		}
+	}
+}
+
+// finishDownload verifies and moves the complete part to file,
+// or deletes it on mismatch.
+func finishDownload(out *os.File, part, meta, file string, v *verifier) error {
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := v.verify(); err != nil {
+		os.Remove(part)
+		os.Remove(meta)
+		return err
+	}
+	if err := os.Rename(part, file); err != nil {
+		return err
+	}
+	os.Remove(meta)
+	return nil
+}
+
+// strongValidator returns the validator usable in If-Range:
+// a strong ETag, or Last-Modified.
+func strongValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// parseContentRange parses "bytes first-last/size",
+// where size is -1 if "*", and "bytes */size".
+func parseContentRange(v string) (first, size int64, ok bool) {
+	if !strings.HasPrefix(v, "bytes ") {
+		return
+	}
+	v = strings.TrimPrefix(v, "bytes ")
+	i := strings.IndexByte(v, '/')
+	if i < 0 {
+		return
+	}
+	rng, total := v[:i], v[i+1:]
+	size = -1
+	if total != "*" {
+		n, err := strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return
+		}
+		size = n
+	}
+	if rng == "*" {
+		return -1, size, size >= 0
+	}
+	j := strings.IndexByte(rng, '-')
+	if j < 0 {
+		return
+	}
+	first, err := strconv.ParseInt(rng[:j], 10, 64)
+	if err != nil {
+		return
+	}
+	return first, size, true
+}
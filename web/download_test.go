@@ -0,0 +1,200 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+// RangeHandler serves content with the ETag, cutting the
+// first n responses short after half of what is asked.
+func RangeHandler(content []byte, etag string, n int) (http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		cut := len(ranges) <= n
+		mu.Unlock()
+
+		w.Header().Set("ETag", etag)
+		if cut {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}
+	return h, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDownloader_Resume(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	h, ranges := RangeHandler(content, `"v1"`, 1)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{Backoff: web.ConstantBackoff{}}
+	a.NoError(d.Download(context.Background(), server.URL, file), "Downloads")
+
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+	a.Equal([]string{"", "bytes=5000-"}, ranges(), "Resumed from the part")
+	_, err := os.Stat(file + ".part")
+	a.True(os.IsNotExist(err), "Part renamed")
+}
+
+func TestDownloader_GiveUp(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	h, _ := RangeHandler(content, `"v1"`, 1)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{MaxTries: 1}
+	a.True(d.Download(context.Background(), server.URL, file) != nil, "Interrupted")
+	_, err := os.Stat(file)
+	a.True(os.IsNotExist(err), "No truncated file")
+	part, _ := ioutil.ReadFile(file + ".part")
+	a.Equal(len(content)/2, len(part), "Part kept")
+
+	// the next call resumes
+	a.NoError(d.Download(context.Background(), server.URL, file), "Resumes")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+}
+
+func TestDownloader_Changed(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	h, ranges := RangeHandler(content, `"v2"`, 0)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	// left by a download of an older version
+	ioutil.WriteFile(file+".part", []byte("old content"), 0644)
+	ioutil.WriteFile(file+".part.meta", []byte(`"v1"`), 0644)
+
+	a.NoError(web.NewDownloader(nil, 0).Download(context.Background(), server.URL, file), "Downloads")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Started over")
+	a.Equal([]string{"bytes=11-"}, ranges(), "Tried to resume")
+}
+
+func TestDownloader_NoValidatorGivesUp(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		// always cut short, and no validator to resume with
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:len(content)/2])
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := &web.Downloader{MaxTries: 3, Backoff: web.ConstantBackoff{}}
+	err := d.Download(context.Background(), server.URL, filepath.Join(dir, "file"))
+	a.True(err != nil, "Gives up")
+	a.Equal(int32(3), atomic.LoadInt32(&count), "No progress after starting over")
+}
+
+func TestDownloader_BadRangeGivesUp(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		// never the range asked for
+		w.Header().Set("Content-Range", "bytes 5-9/10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("56789"))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d := &web.Downloader{MaxTries: 3, Backoff: web.ConstantBackoff{}}
+	err := d.Download(context.Background(), server.URL, filepath.Join(dir, "file"))
+	var se *web.StatusError
+	a.True(errors.As(err, &se), "Gives up")
+	a.Equal(http.StatusPartialContent, se.Status, "Unexpected range")
+	a.Equal(int32(3), atomic.LoadInt32(&count), "Limited by MaxTries")
+}
+
+func TestDownloader_IdleTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if atomic.AddInt32(&count, 1) == 1 {
+			// stalls after half
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+
+	d := &web.Downloader{MaxTries: 1, IdleTimeout: 50 * time.Millisecond}
+	err := d.Download(context.Background(), server.URL, file)
+	a.True(errors.Is(err, web.ErrIdleTimeout), "Stalled body dropped")
+
+	a.NoError(d.Download(context.Background(), server.URL, file), "Resumes")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "Content complete")
+}
@@ -0,0 +1,253 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedgingSamples    = 100 // #recent latencies kept
+	hedgingMinSamples = 10  // #latencies needed to learn the delay
+)
+
+// Hedging configures hedged requests for a client
+// (safe for concurrent use by multiple goroutines).
+// Tries started while another one is still in flight
+// are hedges; others are plain retries after backoff.
+type Hedging struct {
+	// Delay to wait for a response before hedging,
+	// also used until enough latencies are learned.
+	Delay time.Duration
+	// Percentile, if in (0, 1), learns the delay as the
+	// percentile of recent latencies, e.g., 0.95.
+	Percentile float64
+	// MaxInFlight limits hedges in flight at once
+	// for all requests; no limit if <= 0.
+	MaxInFlight int
+
+	mu        sync.Mutex
+	latencies []time.Duration // ring buffer
+	next      int
+	inFlight  int
+}
+
+// NewHedging returns a Hedging with a fixed delay.
+func NewHedging(delay time.Duration, maxInFlight int) *Hedging {
+	return &Hedging{
+		Delay:       delay,
+		MaxInFlight: maxInFlight,
+	}
+}
+
+// HedgeDelay returns the current delay before hedging.
+func (h *Hedging) HedgeDelay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.Percentile <= 0 || h.Percentile >= 1 || len(h.latencies) < hedgingMinSamples {
+		return h.Delay
+	}
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(h.Percentile*float64(len(sorted)-1))]
+}
+
+// observe records the latency of a finished try.
+func (h *Hedging) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgingSamples {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % hedgingSamples
+}
+
+// acquire takes a hedge slot if available.
+func (h *Hedging) acquire() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.MaxInFlight > 0 && h.inFlight >= h.MaxInFlight {
+		return false
+	}
+	h.inFlight++
+	return true
+}
+
+func (h *Hedging) release() {
+	h.mu.Lock()
+	h.inFlight--
+	h.mu.Unlock()
+}
+
+// hedgeResult is the outcome of a single try.
+type hedgeResult struct {
+	id   int
+	resp *http.Response
+	body []byte
+	a    Attempt
+	err  error
+}
+
+// doHedged is Do in hedging mode; it works for requests
+// that can be replayed only.
+func (c *client) doHedged(req *http.Request, maxTries int, policy RetryPolicy) (tries, status int, body []byte, err error) {
+	if maxTries < 1 {
+		// no try, as the sequential loop
+		return
+	}
+	h := c.hedging
+	parent := req.Context()
+	// cancels the losers when returns
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	// tries in flight by launch id
+	pending := make(map[int]Attempt)
+	var attempts []Attempt
+	giveUp := func(reason, e error) error {
+		// the ones still in flight are cancelled
+		var cancelled []Attempt
+		for _, a := range pending {
+			a.Duration = time.Since(a.Start)
+			a.Err = context.Canceled
+			cancelled = append(cancelled, a)
+		}
+		sort.Slice(cancelled, func(i, j int) bool { return cancelled[i].Start.Before(cancelled[j].Start) })
+		return &GiveUpError{Reason: reason, Tries: tries, Err: e, Attempts: append(attempts, cancelled...)}
+	}
+
+	// buffered so that no try blocks after return
+	results := make(chan hedgeResult, maxTries)
+	var delay time.Duration // waited before the next launch
+	launched := 0
+	launch := func(hedge bool) {
+		id := launched
+		launched++
+		tries++
+		r := req.Clone(ctx)
+		if id > 0 && req.Body != nil && req.GetBody != nil {
+			r.Body, _ = req.GetBody()
+		}
+		backoff := delay
+		pending[id] = Attempt{Start: time.Now(), Backoff: backoff}
+		go func() {
+			resp, body, a, err := c.send(r, id+1, backoff, false)
+			if err == nil {
+				h.observe(a.Duration)
+			}
+			if hedge {
+				h.release()
+			}
+			results <- hedgeResult{id, resp, body, a, err}
+		}()
+	}
+
+	timer := time.NewTimer(h.HedgeDelay())
+	defer func() { timer.Stop() }()
+	timerC := timer.C
+	arm := func(d time.Duration) {
+		delay = d
+		timer.Stop()
+		timer = time.NewTimer(d)
+		timerC = timer.C
+	}
+
+	if c.budget != nil {
+		c.budget.deposit()
+	}
+	launch(false)
+	delay = h.HedgeDelay()
+	var backoff time.Duration
+	open := false // breaker is open, stop trying
+	var last hedgeResult
+
+	for {
+		select {
+		case <-timerC:
+			timerC = nil
+			if open || tries >= maxTries {
+				continue
+			}
+			if len(pending) == 0 {
+				// retry after backoff
+				if c.budget != nil && !c.budget.withdraw() {
+					err = giveUp(ErrRetryBudget, err)
+					return
+				}
+				launch(false)
+			} else if h.acquire() {
+				if c.budget != nil && !c.budget.withdraw() {
+					// no hedge without budget
+					h.release()
+				} else {
+					launch(true)
+				}
+			}
+			arm(h.HedgeDelay())
+
+		case r := <-results:
+			delete(pending, r.id)
+			if r.err == ErrCircuitOpen {
+				// no request is made
+				tries--
+				open = true
+				if len(pending) == 0 {
+					err = giveUp(ErrCircuitOpen, last.err)
+					return
+				}
+				continue
+			}
+			if r.a.Start.IsZero() {
+				// the context is done waiting for the rate limiter
+				tries--
+				err = giveUp(ErrContextDone, r.err)
+				return
+			}
+			attempts = append(attempts, r.a)
+			last = r
+			if r.resp != nil {
+				status = r.resp.StatusCode
+			}
+			body, err = r.body, r.err
+			if err != nil && parent.Err() != nil {
+				err = giveUp(ErrContextDone, err)
+				return
+			}
+			// the attempt number of this try, not of the last launched
+			if !policy.Retry(r.id+1, req, r.resp, r.err) {
+				// the first good response wins
+				return
+			}
+			if len(pending) > 0 {
+				continue
+			}
+			if open {
+				err = giveUp(ErrCircuitOpen, err)
+				return
+			}
+			if tries >= maxTries {
+				// with the last response
+				err = giveUp(ErrMaxTries, err)
+				return
+			}
+			backoff = c.bk.Sleep(tries, backoff)
+			sleep := backoff
+			if err == nil && c.retryAfter {
+				sleep = c.retryAfterSleep(r.resp, sleep)
+			}
+			arm(sleep)
+
+		case <-parent.Done():
+			err = giveUp(ErrContextDone, parent.Err())
+			return
+		}
+	}
+}
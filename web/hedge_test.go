@@ -0,0 +1,125 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+// SlowFirstHandler sleeps d for the first request only.
+func SlowFirstHandler(d time.Duration) http.HandlerFunc {
+	var count int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(okResp)
+	}
+}
+
+func TestClientDo_Hedging(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(SlowFirstHandler(time.Second))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithHedging(web.NewHedging(20*time.Millisecond, 1)))
+
+	start := time.Now()
+	n, status, body, err := cl.Do(newGet(t, server.URL), 3)
+	a.True(time.Since(start) < 500*time.Millisecond, "Hedge wins")
+	a.NoError(err, "No error")
+	a.Equal(2, n, "One hedge started")
+	a.Equal(http.StatusOK, status, "Got 200")
+	a.Equal(okResp, body, "Got body")
+}
+
+func TestClientDo_HedgingRetry(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	cl := web.NewClient(
+		web.WithHedging(web.NewHedging(time.Second, 1)),
+		web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}),
+	)
+
+	n, status, _, err := cl.Do(newGet(t, server.URL), 3)
+	a.True(gaveUpOnStatus(err), "Out of tries on 5xx")
+	a.Equal(3, n, "Retried after failures")
+	a.Equal(http.StatusBadGateway, status, "Got 502")
+}
+
+func TestClientDo_HedgingAttempts(t *testing.T) {
+	a := assert.New(t)
+
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			// fails after the hedge starts
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	policy := web.RetryPolicyFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		mu.Lock()
+		seen = append(seen, attempt)
+		mu.Unlock()
+		return web.DefaultPolicy{}.Retry(attempt, req, resp, err)
+	})
+	cl := web.NewClient(
+		web.WithHedging(web.NewHedging(20*time.Millisecond, 1)),
+		web.WithRetryPolicy(policy),
+	)
+
+	n, status, _, err := cl.Do(newGet(t, server.URL), 0)
+	a.NoError(err, "No error")
+	a.Equal(0, n, "No try")
+	a.Equal(0, status, "No status")
+	a.Equal(int32(0), atomic.LoadInt32(&count), "No request")
+
+	n, status, _, err = cl.Do(newGet(t, server.URL), 2)
+	a.NoError(err, "No error")
+	a.Equal(2, n, "One hedge started")
+	a.Equal(http.StatusOK, status, "Hedge wins")
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal([]int{1, 2}, seen, "Attempt numbers of the tries finished")
+}
+
+func TestHedging_Percentile(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(okHandler)
+	defer server.Close()
+
+	h := web.NewHedging(time.Second, 1)
+	h.Percentile = 0.9
+	cl := web.NewClient(web.WithHedging(h))
+
+	a.Equal(time.Second, h.HedgeDelay(), "Fixed delay before learning")
+	for i := 0; i < 20; i++ {
+		cl.Do(newGet(t, server.URL), 1)
+	}
+	a.True(h.HedgeDelay() < time.Second, "Learned from latencies")
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// StatusError is returned by the JSON helpers
+// for non-2xx responses.
+type StatusError struct {
+	Status int
+	Body   []byte
+	Tries  int
+	// Detail is the Body decoded as JSON if possible.
+	Detail interface{}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("web: status %d after %d tries: %s", e.Status, e.Tries, e.Body)
+}
+
+// Decode decodes the JSON error body into v.
+func (e *StatusError) Decode(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+// DoJSON sends in (if not nil) as JSON with the method,
+// then decodes a 2xx JSON response into out (if not nil);
+// a *StatusError is returned for non-2xx responses.
+func DoJSON(ctx context.Context, cl Client, method, url string, in, out interface{}, maxTries int) (tries int, err error) {
+	var req *http.Request
+	if in != nil {
+		req, err = NewJSONRequest(method, url, in)
+	} else {
+		req, err = http.NewRequest(method, url, nil)
+	}
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+
+	tries, status, body, err := cl.Do(req.WithContext(ctx), maxTries)
+	var ge *GiveUpError
+	if errors.Is(err, ErrMaxTries) && errors.As(err, &ge) && ge.Err == nil && status != 0 {
+		// out of tries on the status, reported below
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+
+	if status < 200 || status > 299 {
+		e := &StatusError{Status: status, Body: body, Tries: tries}
+		// best effort
+		if json.Unmarshal(body, &e.Detail) != nil {
+			e.Detail = nil
+		}
+		err = e
+		return
+	}
+
+	if out != nil && len(body) > 0 {
+		err = json.Unmarshal(body, out)
+	}
+	return
+}
+
+// GetJSON is DoJSON with GET.
+func GetJSON(ctx context.Context, cl Client, url string, out interface{}, maxTries int) (int, error) {
+	return DoJSON(ctx, cl, "GET", url, nil, out, maxTries)
+}
+
+// PostJSON is DoJSON with POST.
+func PostJSON(ctx context.Context, cl Client, url string, in, out interface{}, maxTries int) (int, error) {
+	return DoJSON(ctx, cl, "POST", url, in, out, maxTries)
+}
+
+// PutJSON is DoJSON with PUT.
+func PutJSON(ctx context.Context, cl Client, url string, in, out interface{}, maxTries int) (int, error) {
+	return DoJSON(ctx, cl, "PUT", url, in, out, maxTries)
+}
+
+// PatchJSON is DoJSON with PATCH.
+func PatchJSON(ctx context.Context, cl Client, url string, in, out interface{}, maxTries int) (int, error) {
+	return DoJSON(ctx, cl, "PATCH", url, in, out, maxTries)
+}
+
+// DeleteJSON is DoJSON with DELETE.
+func DeleteJSON(ctx context.Context, cl Client, url string, out interface{}, maxTries int) (int, error) {
+	return DoJSON(ctx, cl, "DELETE", url, nil, out, maxTries)
+}
@@ -0,0 +1,107 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+type item struct {
+	Method string `json:"method"`
+	Name   string `json:"name"`
+}
+
+// EchoHandler responds the request method and
+// the decoded name in JSON.
+func EchoHandler(w http.ResponseWriter, r *http.Request) {
+	var in item
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := web.ParseJSONRequest(r, &in); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"bad json"}`))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"method":"` + r.Method + `","name":"` + in.Name + `"}`))
+}
+
+func TestDoJSON(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(EchoHandler))
+	defer server.Close()
+
+	ctx := context.Background()
+	cl := web.NewClient()
+	in := item{Name: "foo"}
+
+	var out item
+	n, err := web.GetJSON(ctx, cl, server.URL, &out, 3)
+	a.NoError(err, "GET")
+	a.Equal(1, n, "One try")
+	a.Equal(item{"GET", ""}, out, "GET decoded")
+
+	for _, test := range []struct {
+		method string
+		do     func(out interface{}) (int, error)
+	}{
+		{"POST", func(out interface{}) (int, error) { return web.PostJSON(ctx, cl, server.URL, in, out, 3) }},
+		{"PUT", func(out interface{}) (int, error) { return web.PutJSON(ctx, cl, server.URL, in, out, 3) }},
+		{"PATCH", func(out interface{}) (int, error) { return web.PatchJSON(ctx, cl, server.URL, in, out, 3) }},
+	} {
+		var out item
+		_, err = test.do(&out)
+		a.NoError(err, test.method)
+		a.Equal(item{test.method, "foo"}, out, test.method+" decoded")
+	}
+
+	out = item{}
+	_, err = web.DeleteJSON(ctx, cl, server.URL, &out, 3)
+	a.NoError(err, "DELETE")
+	a.Equal("DELETE", out.Method, "DELETE decoded")
+}
+
+func TestDoJSON_StatusError(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, []byte(`{"error":"down"}`)))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}))
+	_, err := web.GetJSON(context.Background(), cl, server.URL, nil, 2)
+
+	var se *web.StatusError
+	a.True(errors.As(err, &se), "Typed error")
+	a.Equal(http.StatusBadGateway, se.Status, "Carries status")
+	a.Equal(2, se.Tries, "Carries tries")
+	a.Equal([]byte(`{"error":"down"}`), se.Body, "Carries body")
+	a.Equal(map[string]interface{}{"error": "down"}, se.Detail, "Body decoded")
+
+	var detail struct {
+		Error string `json:"error"`
+	}
+	a.NoError(se.Decode(&detail), "Decode into typed value")
+	a.Equal("down", detail.Error, "Typed detail")
+}
+
+func TestDoJSON_GiveUp(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusServiceUnavailable, []byte(`{"error":"down"}`)))
+	defer server.Close()
+
+	b := &web.Breaker{FailureRatio: 0.5, MinRequests: 1, CoolDown: time.Minute}
+	cl := web.NewClient(web.WithBreaker(b), web.WithBackoff(web.ConstantBackoff{}))
+	_, err := web.GetJSON(context.Background(), cl, server.URL, nil, 3)
+
+	var se *web.StatusError
+	a.True(errors.Is(err, web.ErrCircuitOpen), "Circuit open kept")
+	a.True(!errors.As(err, &se), "Not a status error")
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// RoundTripperFunc is an adapter to allow the use of
+// ordinary functions as http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the next http.RoundTripper in the chain.
+// As a RoundTripper, it should not modify the request
+// but a clone of it.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain wraps rt (http.DefaultTransport if nil)
+// with mws, the first one being the outermost.
+func Chain(rt http.RoundTripper, mws ...Middleware) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		rt = mws[i](rt)
+	}
+	return rt
+}
+
+type (
+	attemptKey   struct{}
+	requestIDKey struct{}
+	callIDKey    struct{}
+)
+
+// AttemptFromContext returns the number (starting from 1)
+// of the try made by a client, 0 if not made by it.
+func AttemptFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey{}).(int)
+	return n
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+// to be propagated by the RequestID middleware.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set by WithRequestID.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// callID is the request ID generated for
+// all tries of a call without one.
+type callID struct {
+	once sync.Once
+	id   string
+}
+
+// withCallID returns req ready to share a generated
+// request ID among its tries, if it has none.
+func withCallID(req *http.Request) *http.Request {
+	if RequestIDFromContext(req.Context()) != "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), callIDKey{}, &callID{}))
+}
+
+// requestID returns the request ID of ctx,
+// generated once per call if not set.
+func requestID(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return id
+	}
+	c, ok := ctx.Value(callIDKey{}).(*callID)
+	if !ok {
+		c = &callID{}
+	}
+	c.once.Do(func() {
+		// best effort
+		c.id, _ = NewIdempotencyKey()
+	})
+	return c.id
+}
+
+// setHeader returns a Middleware that sets the header
+// by set on a clone of each request.
+func setHeader(set func(req *http.Request)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			r := req.Clone(req.Context())
+			set(r)
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// StaticHeaders sets the headers
+// unless the request already has them.
+func StaticHeaders(h http.Header) Middleware {
+	return setHeader(func(req *http.Request) {
+		for k, v := range h {
+			if req.Header.Get(k) == "" {
+				req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+			}
+		}
+	})
+}
+
+// UserAgent sets the User-Agent header.
+func UserAgent(ua string) Middleware {
+	return setHeader(func(req *http.Request) {
+		req.Header.Set("User-Agent", ua)
+	})
+}
+
+// RequestID sets the header (e.g., X-Request-ID) with the
+// request ID from the context (see WithRequestID),
+// unless the request already has it; if the context carries
+// none, an ID is generated once per call and sent on every try,
+// like the Idempotency-Key.
+func RequestID(header string) Middleware {
+	return setHeader(func(req *http.Request) {
+		if req.Header.Get(header) != "" {
+			return
+		}
+		req.Header.Set(header, requestID(req.Context()))
+	})
+}
+
+// BasicAuth sets the Authorization header
+// with HTTP basic authentication.
+func BasicAuth(username, password string) Middleware {
+	return setHeader(func(req *http.Request) {
+		req.SetBasicAuth(username, password)
+	})
+}
+
+// BearerAuth sets the Authorization header with the bearer token.
+func BearerAuth(token string) Middleware {
+	return setHeader(func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	})
+}
@@ -0,0 +1,135 @@
+package web_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestClientDo_Middleware(t *testing.T) {
+	a := assert.New(t)
+
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+	)
+	flaky := FlakyHandler(2, http.StatusBadGateway, "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header)
+		mu.Unlock()
+		flaky(w, r)
+	}))
+	defer server.Close()
+
+	var attempts []int
+	var order []string
+	trace := func(name string) web.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return web.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				if name == "outer" {
+					attempts = append(attempts, web.AttemptFromContext(req.Context()))
+				}
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	hc := &http.Client{Timeout: time.Second}
+	cl := web.NewClient(
+		web.WithHTTPClient(hc),
+		web.WithBackoff(web.ConstantBackoff{Delay: time.Millisecond}),
+		web.WithMiddleware(trace("outer"), trace("inner")),
+		web.WithMiddleware(
+			web.StaticHeaders(http.Header{"X-Static": {"static"}, "X-Mine": {"theirs"}}),
+			web.UserAgent("web-test"),
+			web.RequestID("X-Request-ID"),
+			web.BearerAuth("token"),
+		),
+	)
+	a.True(hc.Transport == nil, "The given http.Client is not modified")
+
+	req := newGet(t, server.URL)
+	req.Header.Set("X-Mine", "mine")
+	req = req.WithContext(web.WithRequestID(context.Background(), "req-1"))
+	n, status, _, err := cl.Do(req, 3)
+	a.NoError(err, "Succeed")
+	a.Equal(3, n, "3 tries")
+	a.Equal(http.StatusOK, status, "Got 200")
+	a.Equal([]int{1, 2, 3}, attempts, "Middleware sees every try")
+	a.Equal("outer", order[0], "First is outermost")
+	a.Equal("inner", order[1], "Then inner")
+	a.Equal("", req.Header.Get("Authorization"), "The request is not modified")
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal(3, len(headers), "3 requests received")
+	for _, h := range headers {
+		a.Equal("static", h.Get("X-Static"), "Static header")
+		a.Equal("mine", h.Get("X-Mine"), "Keep the request's header")
+		a.Equal("web-test", h.Get("User-Agent"), "User-Agent")
+		a.Equal("req-1", h.Get("X-Request-ID"), "Request ID propagated")
+		a.Equal("Bearer token", h.Get("Authorization"), "Bearer auth")
+	}
+}
+
+func TestRequestID_PerCall(t *testing.T) {
+	a := assert.New(t)
+
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		if len(ids)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(
+		web.WithBackoff(web.ConstantBackoff{Delay: time.Millisecond}),
+		web.WithMiddleware(web.RequestID("X-Request-ID")),
+	)
+	for i := 0; i < 2; i++ {
+		n, _, _, err := cl.Do(newGet(t, server.URL), 3)
+		a.NoError(err, "Succeed")
+		a.Equal(3, n, "3 tries")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal(6, len(ids), "6 requests received")
+	a.True(ids[0] != "", "ID generated")
+	a.Equal(ids[0], ids[1], "Same ID on retry")
+	a.Equal(ids[0], ids[2], "Same ID on every try")
+	a.Equal(ids[3], ids[5], "Same ID for the next call")
+	a.True(ids[0] != ids[3], "New ID per call")
+}
+
+func TestBasicAuth(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	cl := web.NewClient(web.WithMiddleware(web.BasicAuth("user", "pass")))
+	_, status, _, err := cl.Do(newGet(t, server.URL), 1)
+	a.NoError(err, "Succeed")
+	a.Equal(http.StatusOK, status, "Authorized")
+}
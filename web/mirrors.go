@@ -0,0 +1,196 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mirrors is a set of base URLs serving the same resources
+// (safe for concurrent use by multiple goroutines).
+// A client with Mirrors sends every request to them instead of
+// the request URL's host: the path is appended to the base URL,
+// and each try moves to the next mirror, in order or picked by
+// weight. A mirror failing a try is unhealthy for CoolDown,
+// tried only after the healthy ones.
+type Mirrors struct {
+	CoolDown time.Duration
+	// Rand picks the weighted mirrors,
+	// the global source of math/rand if nil.
+	Rand RandSource
+
+	mu       sync.Mutex
+	mirrors  []*mirror
+	weighted bool
+}
+
+type mirror struct {
+	base   *url.URL
+	weight int
+	until  time.Time // unhealthy until
+}
+
+// mirrorsKey is the context key of
+// the mirrors to try for a request.
+type mirrorsKey struct{}
+
+// NewMirrors returns Mirrors tried in the order of urls.
+func NewMirrors(coolDown time.Duration, urls ...string) (*Mirrors, error) {
+	weights := make([]int, len(urls))
+	return newMirrors(coolDown, urls, weights, false)
+}
+
+// NewWeightedMirrors returns Mirrors tried in random order,
+// each first in proportion to its weight (> 0).
+func NewWeightedMirrors(coolDown time.Duration, weights map[string]int) (*Mirrors, error) {
+	var urls []string
+	for u := range weights {
+		urls = append(urls, u)
+	}
+	// deterministic for the same Rand
+	sort.Strings(urls)
+	ws := make([]int, len(urls))
+	for i, u := range urls {
+		if weights[u] <= 0 {
+			return nil, errors.New("web: mirror weight must be positive")
+		}
+		ws[i] = weights[u]
+	}
+	return newMirrors(coolDown, urls, ws, true)
+}
+
+func newMirrors(coolDown time.Duration, urls []string, weights []int, weighted bool) (*Mirrors, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("web: no mirror")
+	}
+	m := &Mirrors{CoolDown: coolDown, weighted: weighted}
+	for i, s := range urls {
+		u, err := parseBase(s)
+		if err != nil {
+			return nil, err
+		}
+		m.mirrors = append(m.mirrors, &mirror{base: u, weight: weights[i]})
+	}
+	return m, nil
+}
+
+// Unhealthy returns the base URLs cooling down.
+func (m *Mirrors) Unhealthy() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var urls []string
+	now := time.Now()
+	for _, mr := range m.mirrors {
+		if now.Before(mr.until) {
+			urls = append(urls, mr.base.String())
+		}
+	}
+	return urls
+}
+
+// order returns the mirrors to try:
+// the healthy ones first, by order or weight,
+// then the others by the end of the cool-down.
+func (m *Mirrors) order() []*mirror {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var healthy, unhealthy []*mirror
+	for _, mr := range m.mirrors {
+		if now.Before(mr.until) {
+			unhealthy = append(unhealthy, mr)
+		} else {
+			healthy = append(healthy, mr)
+		}
+	}
+	if m.weighted {
+		healthy = m.shuffle(healthy)
+	}
+	sort.SliceStable(unhealthy, func(i, j int) bool { return unhealthy[i].until.Before(unhealthy[j].until) })
+	return append(healthy, unhealthy...)
+}
+
+// shuffle returns a weighted random permutation
+// of ms; the lock must be held.
+func (m *Mirrors) shuffle(ms []*mirror) []*mirror {
+	rest := append([]*mirror(nil), ms...)
+	out := make([]*mirror, 0, len(ms))
+	for len(rest) > 0 {
+		var sum int64
+		for _, mr := range rest {
+			sum += int64(mr.weight)
+		}
+		var n int64
+		if m.Rand != nil {
+			n = m.Rand.Int63n(sum)
+		} else {
+			n = rand.Int63n(sum)
+		}
+		i := 0
+		for ; n >= int64(rest[i].weight); i++ {
+			n -= int64(rest[i].weight)
+		}
+		out = append(out, rest[i])
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return out
+}
+
+// withOrder fixes the mirrors to try for req.
+func (m *Mirrors) withOrder(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), mirrorsKey{}, m.order()))
+}
+
+// route returns req for the mirror of the attempt.
+func (m *Mirrors) route(req *http.Request, attempt int) (*http.Request, *mirror) {
+	order, _ := req.Context().Value(mirrorsKey{}).([]*mirror)
+	if len(order) == 0 {
+		order = m.order()
+	}
+	mr := order[(attempt-1)%len(order)]
+	r := req.Clone(req.Context())
+	r.URL = rebase(req.URL, mr.base)
+	r.Host = ""
+	return r, mr
+}
+
+// record marks mr healthy or not.
+func (m *Mirrors) record(mr *mirror, failure bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if failure {
+		mr.until = time.Now().Add(m.CoolDown)
+	} else {
+		mr.until = time.Time{}
+	}
+}
+
+// parseBase parses an absolute base URL.
+func parseBase(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("web: base URL without scheme or host: " + s)
+	}
+	return u, nil
+}
+
+// rebase returns u with the path appended to base.
+func rebase(u, base *url.URL) *url.URL {
+	v := *u
+	v.Scheme, v.Host, v.User = base.Scheme, base.Host, base.User
+	v.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+	v.RawPath = ""
+	return &v
+}
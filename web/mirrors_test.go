@@ -0,0 +1,116 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+// PathHandler records the paths requested.
+func PathHandler(code int) (http.HandlerFunc, func() []string) {
+	var mu sync.Mutex
+	var paths []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+	}
+	return h, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func TestClientDo_Mirrors(t *testing.T) {
+	a := assert.New(t)
+
+	badH, badPaths := PathHandler(http.StatusServiceUnavailable)
+	bad := httptest.NewServer(badH)
+	defer bad.Close()
+	goodH, goodPaths := PathHandler(http.StatusOK)
+	good := httptest.NewServer(goodH)
+	defer good.Close()
+
+	m, err := web.NewMirrors(time.Minute, bad.URL+"/base/", good.URL)
+	a.NoError(err, "Mirrors")
+	cl := web.NewClient(web.WithMirrors(m), web.WithBackoff(web.ConstantBackoff{}))
+
+	tries, status, _, err := cl.Do(newGet(t, "http://artifacts/pkg/x.tgz"), 3)
+	a.NoError(err, "Fails over")
+	a.Equal(2, tries, "Next mirror on the second try")
+	a.Equal(http.StatusOK, status, "From the good mirror")
+	a.Equal([]string{"/base/pkg/x.tgz"}, badPaths(), "Path under the base")
+	a.Equal([]string{"/pkg/x.tgz"}, goodPaths(), "Path kept")
+	a.Equal([]string{bad.URL + "/base/"}, m.Unhealthy(), "Bad one cooling down")
+
+	tries, _, _, _ = cl.Do(newGet(t, "http://artifacts/pkg/y.tgz"), 3)
+	a.Equal(1, tries, "Unhealthy one skipped")
+	a.Equal(1, len(badPaths()), "Not hit again")
+
+	_, err = web.NewMirrors(time.Minute)
+	a.True(err != nil, "No mirror")
+	_, err = web.NewMirrors(time.Minute, "/relative")
+	a.True(err != nil, "No host")
+}
+
+func TestClientDo_WeightedMirrors(t *testing.T) {
+	a := assert.New(t)
+
+	var light, heavy int32
+	lightS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&light, 1)
+	}))
+	defer lightS.Close()
+	heavyS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&heavy, 1)
+	}))
+	defer heavyS.Close()
+
+	m, err := web.NewWeightedMirrors(time.Minute, map[string]int{lightS.URL: 1, heavyS.URL: 4})
+	a.NoError(err, "Mirrors")
+	m.Rand = web.NewLockedRand(1)
+	cl := web.NewClient(web.WithMirrors(m))
+	for i := 0; i < 200; i++ {
+		cl.Do(newGet(t, "http://artifacts/"), 1)
+	}
+	a.Equal(int32(200), light+heavy, "All served")
+	a.True(heavy > 2*light, "By weight")
+
+	_, err = web.NewWeightedMirrors(time.Minute, map[string]int{lightS.URL: 0})
+	a.True(err != nil, "Positive weights only")
+}
+
+func TestDownloader_Mirrors(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	bad := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer bad.Close()
+	h, _ := RangeHandler(content, `"v1"`, 0)
+	good := httptest.NewServer(h)
+	defer good.Close()
+
+	m, _ := web.NewMirrors(time.Minute, bad.URL, good.URL)
+	d := web.NewDownloader(web.NewClient(web.WithMirrors(m), web.WithBackoff(web.ConstantBackoff{})), 2)
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	a.NoError(d.Download(context.Background(), "http://artifacts/file", file), "Downloads")
+	got, _ := ioutil.ReadFile(file)
+	a.True(bytes.Equal(content, got), "From the good mirror")
+}
@@ -0,0 +1,291 @@
+package web
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases holds the timings of a try traced by net/http/httptrace;
+// a phase is 0 if it does not happen, e.g., for a reused connection.
+type Phases struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// FirstByte is the time to the first response byte
+	// since the try starts.
+	FirstByte time.Duration
+	Reused    bool // connection reused
+}
+
+// AttemptInfo describes a try reported to an Observer.
+type AttemptInfo struct {
+	Attempt
+	Request *http.Request
+	Number  int // starting from 1, i.e., retries so far + 1
+	Phases  Phases
+}
+
+// Observer is notified of every try made by a client,
+// e.g., for metrics and tracing; it is called synchronously
+// and should be safe for concurrent use.
+type Observer interface {
+	ObserveAttempt(info AttemptInfo)
+}
+
+// traceTimer records the moments of a try by httptrace.
+type traceTimer struct {
+	mu                 sync.Mutex
+	dnsStart, dnsDone  time.Time
+	connStart, connEnd time.Time
+	tlsStart, tlsDone  time.Time
+	firstByte          time.Time
+	reused             bool
+}
+
+func (t *traceTimer) clientTrace() *httptrace.ClientTrace {
+	// keep the first moment only, e.g., for multiple dials
+	mark := func(p *time.Time) {
+		t.mu.Lock()
+		if p.IsZero() {
+			*p = time.Now()
+		}
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { mark(&t.dnsDone) },
+		ConnectStart:         func(string, string) { mark(&t.connStart) },
+		ConnectDone:          func(string, string, error) { mark(&t.connEnd) },
+		TLSHandshakeStart:    func() { mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { mark(&t.tlsDone) },
+		GotFirstResponseByte: func() { mark(&t.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+	}
+}
+
+// phases returns the timings of the try started at start.
+func (t *traceTimer) phases(start time.Time) Phases {
+	if t == nil {
+		return Phases{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	between := func(from, to time.Time) time.Duration {
+		if from.IsZero() || to.IsZero() {
+			return 0
+		}
+		return to.Sub(from)
+	}
+	return Phases{
+		DNS:       between(t.dnsStart, t.dnsDone),
+		Connect:   between(t.connStart, t.connEnd),
+		TLS:       between(t.tlsStart, t.tlsDone),
+		FirstByte: between(start, t.firstByte),
+		Reused:    t.reused,
+	}
+}
+
+// DefaultBuckets are the default histogram buckets
+// of a Collector in seconds (as of Prometheus).
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative-on-output histogram.
+type histogram struct {
+	counts []int64 // per bucket, non-cumulative
+	count  int64
+	sum    float64
+}
+
+type (
+	phaseKey struct{ host, phase string }
+	triesKey struct{ host, outcome string }
+)
+
+// Collector is an in-memory Observer keeping per-host latency
+// histograms of tries and their phases, and counters of
+// tries, retries and backoff, which can be dumped in
+// the Prometheus text format by WritePrometheus
+// (safe for concurrent use by multiple goroutines).
+type Collector struct {
+	buckets []float64
+
+	mu      sync.Mutex
+	hists   map[phaseKey]*histogram
+	tries   map[triesKey]int64
+	retries map[string]int64
+	backoff map[string]float64 // in seconds
+}
+
+// NewCollector returns a Collector with the given histogram
+// buckets (upper bounds in seconds), or DefaultBuckets if none.
+func NewCollector(buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Collector{
+		buckets: sorted,
+		hists:   make(map[phaseKey]*histogram),
+		tries:   make(map[triesKey]int64),
+		retries: make(map[string]int64),
+		backoff: make(map[string]float64),
+	}
+}
+
+// ObserveAttempt implements Observer.
+func (c *Collector) ObserveAttempt(info AttemptInfo) {
+	host := info.Request.URL.Host
+	outcome := "error"
+	if info.Err == nil {
+		outcome = strconv.Itoa(info.Status)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tries[triesKey{host, outcome}]++
+	if info.Number > 1 {
+		c.retries[host]++
+	}
+	c.backoff[host] += info.Backoff.Seconds()
+
+	c.observe(host, "total", info.Duration)
+	for _, p := range []struct {
+		phase string
+		d     time.Duration
+	}{
+		{"dns", info.Phases.DNS},
+		{"connect", info.Phases.Connect},
+		{"tls", info.Phases.TLS},
+		{"first_byte", info.Phases.FirstByte},
+	} {
+		if p.d > 0 {
+			c.observe(host, p.phase, p.d)
+		}
+	}
+}
+
+// observe adds d to the histogram; the lock must be held.
+func (c *Collector) observe(host, phase string, d time.Duration) {
+	k := phaseKey{host, phase}
+	h, ok := c.hists[k]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(c.buckets))}
+		c.hists[k] = h
+	}
+	v := d.Seconds()
+	h.count++
+	h.sum += v
+	for i, le := range c.buckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+}
+
+// WritePrometheus dumps the metrics in the Prometheus text format:
+// web_client_try_duration_seconds{host,phase} histograms,
+// web_client_tries_total{host,outcome},
+// web_client_retries_total{host} and
+// web_client_backoff_seconds_total{host} counters.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	const hist = "web_client_try_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Duration of tries by phase.\n# TYPE %s histogram\n", hist, hist)
+	hkeys := make([]phaseKey, 0, len(c.hists))
+	for k := range c.hists {
+		hkeys = append(hkeys, k)
+	}
+	sort.Slice(hkeys, func(i, j int) bool {
+		if hkeys[i].host != hkeys[j].host {
+			return hkeys[i].host < hkeys[j].host
+		}
+		return hkeys[i].phase < hkeys[j].phase
+	})
+	for _, k := range hkeys {
+		h := c.hists[k]
+		labels := fmt.Sprintf(`host="%s",phase="%s"`, escapeLabel(k.host), k.phase)
+		var cum int64
+		for i, le := range c.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", hist, labels, formatFloat(le), cum)
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", hist, labels, h.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", hist, labels, formatFloat(h.sum))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", hist, labels, h.count)
+	}
+
+	const tries = "web_client_tries_total"
+	fmt.Fprintf(bw, "# HELP %s Tries by outcome (status code or error).\n# TYPE %s counter\n", tries, tries)
+	tkeys := make([]triesKey, 0, len(c.tries))
+	for k := range c.tries {
+		tkeys = append(tkeys, k)
+	}
+	sort.Slice(tkeys, func(i, j int) bool {
+		if tkeys[i].host != tkeys[j].host {
+			return tkeys[i].host < tkeys[j].host
+		}
+		return tkeys[i].outcome < tkeys[j].outcome
+	})
+	for _, k := range tkeys {
+		fmt.Fprintf(bw, "%s{host=\"%s\",outcome=\"%s\"} %d\n", tries, escapeLabel(k.host), k.outcome, c.tries[k])
+	}
+
+	const retries = "web_client_retries_total"
+	fmt.Fprintf(bw, "# HELP %s Tries after the first one.\n# TYPE %s counter\n", retries, retries)
+	for _, host := range sortedKeys(c.retries) {
+		fmt.Fprintf(bw, "%s{host=\"%s\"} %d\n", retries, escapeLabel(host), c.retries[host])
+	}
+
+	const backoff = "web_client_backoff_seconds_total"
+	fmt.Fprintf(bw, "# HELP %s Time slept before tries.\n# TYPE %s counter\n", backoff, backoff)
+	hosts := make([]string, 0, len(c.backoff))
+	for host := range c.backoff {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(bw, "%s{host=\"%s\"} %s\n", backoff, escapeLabel(host), formatFloat(c.backoff[host]))
+	}
+
+	return bw.Flush()
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
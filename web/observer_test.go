@@ -0,0 +1,90 @@
+package web_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	infos []web.AttemptInfo
+}
+
+func (r *recorder) ObserveAttempt(info web.AttemptInfo) {
+	r.mu.Lock()
+	r.infos = append(r.infos, info)
+	r.mu.Unlock()
+}
+
+func TestClientDo_Observer(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(FlakyHandler(1, http.StatusBadGateway, ""))
+	defer server.Close()
+
+	rec := &recorder{}
+	cl := web.NewClient(
+		web.WithObserver(rec),
+		web.WithBackoff(web.ConstantBackoff{Delay: 5 * time.Millisecond}),
+	)
+	n, _, _, err := cl.Do(newGet(t, server.URL), 3)
+	a.NoError(err, "Succeed")
+	a.Equal(2, n, "2 tries")
+
+	a.Equal(2, len(rec.infos), "Every try observed")
+	first, second := rec.infos[0], rec.infos[1]
+	a.Equal(1, first.Number, "#1")
+	a.Equal(http.StatusBadGateway, first.Status, "#1 outcome")
+	a.Equal(time.Duration(0), first.Backoff, "No backoff before #1")
+	a.True(first.Phases.Connect > 0, "#1 connects")
+	a.True(first.Phases.FirstByte > 0, "#1 time to first byte")
+	a.Equal(2, second.Number, "#2")
+	a.Equal(http.StatusOK, second.Status, "#2 outcome")
+	a.Equal(5*time.Millisecond, second.Backoff, "Backoff before #2")
+	a.True(second.Phases.Reused, "#2 reuses the connection")
+	a.Equal(time.Duration(0), second.Phases.Connect, "#2 does not connect")
+}
+
+func TestCollector(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(FlakyHandler(1, http.StatusBadGateway, ""))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	col := web.NewCollector(0.5, 1)
+	cl := web.NewClient(
+		web.WithObserver(col),
+		web.WithBackoff(web.ConstantBackoff{Delay: 5 * time.Millisecond}),
+	)
+	cl.Do(newGet(t, server.URL), 3)
+
+	var buf bytes.Buffer
+	a.NoError(col.WritePrometheus(&buf), "Write")
+	out := buf.String()
+
+	host := `host="` + u.Host + `"`
+	for _, line := range []string{
+		"# TYPE web_client_try_duration_seconds histogram",
+		`web_client_try_duration_seconds_bucket{` + host + `,phase="total",le="0.5"} 2`,
+		`web_client_try_duration_seconds_bucket{` + host + `,phase="total",le="1"} 2`,
+		`web_client_try_duration_seconds_bucket{` + host + `,phase="total",le="+Inf"} 2`,
+		`web_client_try_duration_seconds_count{` + host + `,phase="total"} 2`,
+		`web_client_try_duration_seconds_count{` + host + `,phase="connect"} 1`,
+		`web_client_tries_total{` + host + `,outcome="200"} 1`,
+		`web_client_tries_total{` + host + `,outcome="502"} 1`,
+		`web_client_retries_total{` + host + `} 1`,
+		`web_client_backoff_seconds_total{` + host + `} 0.005`,
+	} {
+		a.True(strings.Contains(out, line+"\n"), "Contains "+line)
+	}
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress of a download.
+type Progress struct {
+	Done  int64         // bytes
+	Total int64         // bytes, -1 if unknown
+	Rate  float64       // bytes per second of this call
+	ETA   time.Duration // -1 if unknown
+}
+
+// ProgressChan returns an OnProgress sending to ch,
+// dropping the reports ch is not ready for.
+func ProgressChan(ch chan<- Progress) func(Progress) {
+	return func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// meter throttles and reports the progress of a download.
+type meter struct {
+	limiter    *RateLimiter // nil if no limit
+	limit      int
+	onProgress func(Progress)
+	interval   time.Duration
+
+	mu       sync.Mutex
+	start    time.Time
+	base     int64 // done before the call, excluded from Rate
+	done     int64
+	total    int64
+	reported time.Time
+}
+
+func (d *Downloader) newMeter() *meter {
+	m := &meter{
+		onProgress: d.OnProgress,
+		interval:   d.ProgressInterval,
+		start:      time.Now(),
+		total:      -1,
+	}
+	if m.interval <= 0 {
+		m.interval = time.Second
+	}
+	if d.BandwidthLimit > 0 {
+		m.limit = int(d.BandwidthLimit)
+		// a second worth of burst
+		m.limiter = NewRateLimiter(float64(d.BandwidthLimit), m.limit)
+	}
+	return m
+}
+
+// reset sets the bytes done and the total
+// as of a (re)started response.
+func (m *meter) reset(done, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done == 0 || done < m.base {
+		// from the part left before
+		m.base = done
+	}
+	m.done, m.total = done, total
+}
+
+// add counts n bytes done, reporting if due.
+func (m *meter) add(n int) {
+	m.mu.Lock()
+	m.done += int64(n)
+	now := time.Now()
+	due := m.onProgress != nil && now.Sub(m.reported) >= m.interval
+	var p Progress
+	if due {
+		m.reported = now
+		p = m.progress(now)
+	}
+	m.mu.Unlock()
+
+	if due {
+		m.onProgress(p)
+	}
+}
+
+// finish reports the completion.
+func (m *meter) finish() {
+	if m.onProgress == nil {
+		return
+	}
+	m.mu.Lock()
+	m.total = m.done
+	p := m.progress(time.Now())
+	m.mu.Unlock()
+	m.onProgress(p)
+}
+
+// progress returns the Progress; the lock must be held.
+func (m *meter) progress(now time.Time) Progress {
+	p := Progress{Done: m.done, Total: m.total, ETA: -1}
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 {
+		p.Rate = float64(m.done-m.base) / elapsed
+	}
+	if m.total >= 0 && p.Rate > 0 {
+		p.ETA = time.Duration(float64(m.total-m.done) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+// reader meters r, waiting for the bandwidth with ctx.
+func (m *meter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &meterReader{ctx, r, m}
+}
+
+type meterReader struct {
+	ctx context.Context
+	r   io.Reader
+	m   *meter
+}
+
+func (r *meterReader) Read(p []byte) (int, error) {
+	if r.m.limit > 0 && len(p) > r.m.limit {
+		p = p[:r.m.limit]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if r.m.limiter != nil {
+			if werr := r.m.limiter.WaitN(r.ctx, "", n); werr != nil && err == nil {
+				err = werr
+			}
+		}
+		r.m.add(n)
+	}
+	return n, err
+}
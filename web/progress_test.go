@@ -0,0 +1,87 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestDownloader_Progress(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	h, _ := RangeHandler(content, `"v1"`, 0)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, workers := range []int{1, 4} {
+		var mu sync.Mutex
+		var reports []web.Progress
+		d := &web.Downloader{
+			Workers:          workers,
+			ChunkSize:        10000,
+			ProgressInterval: time.Nanosecond,
+			OnProgress: func(p web.Progress) {
+				mu.Lock()
+				reports = append(reports, p)
+				mu.Unlock()
+			},
+		}
+		file := filepath.Join(dir, "file")
+		os.Remove(file)
+		a.NoError(d.Download(context.Background(), server.URL, file), "Downloads")
+
+		mu.Lock()
+		a.True(len(reports) > 1, "Reported while reading")
+		last := reports[len(reports)-1]
+		a.Equal(int64(len(content)), last.Done, "All done")
+		a.Equal(int64(len(content)), last.Total, "Total known")
+		a.Equal(time.Duration(0), last.ETA, "Nothing left")
+		a.True(last.Rate > 0, "Rate measured")
+		for i := 1; i < len(reports); i++ {
+			a.True(reports[i].Done >= reports[i-1].Done, "Monotonic")
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDownloader_BandwidthLimit(t *testing.T) {
+	a := assert.New(t)
+
+	content := bytes.Repeat([]byte("0123456789"), 3000)
+	h, _ := RangeHandler(content, `"v1"`, 0)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// a burst of 20000 then 10000 more at 20000/s
+	d := &web.Downloader{BandwidthLimit: 20000}
+	start := time.Now()
+	a.NoError(d.Download(context.Background(), server.URL, filepath.Join(dir, "file")), "Downloads")
+	elapsed := time.Since(start)
+	a.True(elapsed >= 400*time.Millisecond, "Throttled")
+	a.True(elapsed < 2*time.Second, "Not too much")
+}
+
+func TestProgressChan(t *testing.T) {
+	a := assert.New(t)
+
+	ch := make(chan web.Progress, 1)
+	report := web.ProgressChan(ch)
+	report(web.Progress{Done: 1})
+	report(web.Progress{Done: 2}) // dropped, never blocks
+	a.Equal(int64(1), (<-ch).Done, "First report")
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the requests
+// of a client, as a whole or per host
+// (safe for concurrent use by multiple goroutines).
+// Every try, retries and hedges included, takes a token;
+// Rate tokens are added every second up to Burst.
+type RateLimiter struct {
+	Rate    float64
+	Burst   int
+	PerHost bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens which
+// go negative for the ones reserved.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for the whole client.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst}
+}
+
+// NewHostRateLimiter returns a RateLimiter for each host.
+func NewHostRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, PerHost: true}
+}
+
+// Wait blocks until a token for the host is available
+// or ctx is done, returning ctx.Err() for the latter.
+func (l *RateLimiter) Wait(ctx context.Context, host string) error {
+	return l.WaitN(ctx, host, 1)
+}
+
+// WaitN is Wait for n tokens.
+func (l *RateLimiter) WaitN(ctx context.Context, host string, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d := l.reserve(host, n)
+	if err := sleepContext(ctx, d); err != nil {
+		// give the reserved tokens back
+		l.cancel(host, n)
+		return err
+	}
+	return nil
+}
+
+// bucket returns the host's bucket, creating a full one
+// if missing; the lock must be held.
+func (l *RateLimiter) bucket(host string, now time.Time) *tokenBucket {
+	if !l.PerHost {
+		host = ""
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[host] = b
+		return b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.Rate
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+	return b
+}
+
+// reserve takes n tokens and returns how long to wait for them.
+func (l *RateLimiter) reserve(host string, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(host, time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 || l.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.Rate * float64(time.Second))
+}
+
+func (l *RateLimiter) cancel(host string, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(host, time.Now())
+	b.tokens += float64(n)
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+}
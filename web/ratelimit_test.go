@@ -0,0 +1,59 @@
+package web_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	a := assert.New(t)
+
+	l := web.NewHostRateLimiter(20, 1)
+	ctx := context.Background()
+
+	start := time.Now()
+	a.NoError(l.Wait(ctx, "a"), "Burst")
+	a.NoError(l.Wait(ctx, "b"), "Another host")
+	a.True(time.Since(start) < 25*time.Millisecond, "No wait within burst")
+	a.NoError(l.Wait(ctx, "a"), "Wait")
+	a.True(time.Since(start) >= 40*time.Millisecond, "Wait for a token")
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	a.True(errors.Is(l.Wait(ctx, "a"), context.DeadlineExceeded), "Respect the context")
+	// the token is given back
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	a.NoError(l.Wait(context.Background(), "a"), "Token available")
+	a.True(time.Since(start) < 25*time.Millisecond, "No wait")
+}
+
+func TestClientDo_RateLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusBadGateway, errResp))
+	defer server.Close()
+
+	cl := web.NewClient(
+		web.WithRateLimiter(web.NewRateLimiter(20, 1)),
+		web.WithBackoff(web.ConstantBackoff{Delay: time.Millisecond}),
+	)
+
+	start := time.Now()
+	n, _, _, _ := cl.Do(newGet(t, server.URL), 3)
+	a.Equal(3, n, "All tries made")
+	a.True(time.Since(start) >= 90*time.Millisecond, "Retries take tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n, _, _, err := cl.Do(newGet(t, server.URL).WithContext(ctx), 3)
+	a.Equal(0, n, "No try made")
+	a.True(errors.Is(err, web.ErrContextDone), "Context done waiting")
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// IdempotencyKeyHeader is the header that marks
+// a non-idempotent request safe to retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IsIdempotent reports whether req is safe to retry, i.e.,
+// its method is idempotent as defined in RFC 7231
+// or it carries an Idempotency-Key header.
+func IsIdempotent(req *http.Request) bool {
+	return isIdempotentMethod(req.Method) || req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		// "" means GET for http.Client
+		return true
+	}
+	return false
+}
+
+// NewIdempotencyKey returns a random (version 4) UUID
+// to be used as the Idempotency-Key.
+func NewIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// RetryPolicy decides whether Client.Do should try
+// the request again after an attempt.
+type RetryPolicy interface {
+	// Retry reports whether to retry after the attempt-th
+	// (starting from 1) try of req, which got either resp
+	// (whose Body must not be read) or err;
+	// resp is nil if no response is received.
+	Retry(attempt int, req *http.Request, resp *http.Response, err error) bool
+}
+
+// RetryPolicyFunc is an adapter to allow the use of
+// ordinary functions as RetryPolicy.
+type RetryPolicyFunc func(attempt int, req *http.Request, resp *http.Response, err error) bool
+
+// Retry calls f(attempt, req, resp, err).
+func (f RetryPolicyFunc) Retry(attempt int, req *http.Request, resp *http.Response, err error) bool {
+	return f(attempt, req, resp, err)
+}
+
+// DefaultPolicy is the RetryPolicy a client uses if not set;
+// it retries on errors (timeout only if TimeoutOnly,
+// never ErrBodyTooLarge) and ShouldRetry status codes
+// (also 429 if TooManyRequests).
+type DefaultPolicy struct {
+	TimeoutOnly     bool
+	TooManyRequests bool
+}
+
+// Retry implements RetryPolicy.
+func (p DefaultPolicy) Retry(attempt int, req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			return false
+		}
+		return !p.TimeoutOnly || IsTimeoutErr(err)
+	}
+	return ShouldRetry(resp.StatusCode) ||
+		(p.TooManyRequests && resp.StatusCode == http.StatusTooManyRequests)
+}
+
+// RetryStatus returns a RetryPolicy that retries
+// the given status codes besides what p retries,
+// e.g., RetryStatus(p, http.StatusConflict).
+func RetryStatus(p RetryPolicy, codes ...int) RetryPolicy {
+	return RetryPolicyFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		if err == nil && hasStatus(resp, codes) {
+			return true
+		}
+		return p.Retry(attempt, req, resp, err)
+	})
+}
+
+// NoRetryStatus returns a RetryPolicy that never retries
+// the given status codes but otherwise follows p,
+// e.g., NoRetryStatus(p, 501, 505, 511).
+func NoRetryStatus(p RetryPolicy, codes ...int) RetryPolicy {
+	return RetryPolicyFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		if err == nil && hasStatus(resp, codes) {
+			return false
+		}
+		return p.Retry(attempt, req, resp, err)
+	})
+}
+
+func hasStatus(resp *http.Response, codes []int) bool {
+	if resp == nil {
+		return false
+	}
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
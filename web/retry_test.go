@@ -0,0 +1,81 @@
+package web_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ShevaXu/golang/assert"
+	"github.com/ShevaXu/golang/web"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	req := newGet(t, "/")
+	resp := func(code int) *http.Response {
+		return &http.Response{StatusCode: code}
+	}
+	timeout := &timeoutErr{}
+	other := errors.New("reset")
+
+	p := web.DefaultPolicy{}
+	a.True(p.Retry(1, req, resp(500), nil), "Retry 5xx")
+	a.True(!p.Retry(1, req, resp(429), nil), "Not 429 by default")
+	a.True(!p.Retry(1, req, resp(200), nil), "Not 200")
+	a.True(p.Retry(1, req, nil, other), "Retry all errors")
+
+	p = web.DefaultPolicy{TimeoutOnly: true, TooManyRequests: true}
+	a.True(p.Retry(1, req, resp(429), nil), "Retry 429")
+	a.True(p.Retry(1, req, nil, timeout), "Retry timeout")
+	a.True(!p.Retry(1, req, nil, other), "Not other errors")
+}
+
+func TestRetryStatus(t *testing.T) {
+	a := assert.New(t)
+
+	req := newGet(t, "/")
+	resp := func(code int) *http.Response {
+		return &http.Response{StatusCode: code}
+	}
+
+	p := web.RetryStatus(web.DefaultPolicy{}, http.StatusConflict)
+	a.True(p.Retry(1, req, resp(409), nil), "Retry 409 as added")
+	a.True(p.Retry(1, req, resp(502), nil), "Retry 502 as default")
+	a.True(!p.Retry(1, req, resp(404), nil), "Not 404")
+
+	p = web.NoRetryStatus(web.DefaultPolicy{}, 501, 505, 511)
+	a.True(!p.Retry(1, req, resp(501), nil), "Not 501 as excluded")
+	a.True(p.Retry(1, req, resp(502), nil), "Retry 502 as default")
+	a.True(p.Retry(1, req, nil, errors.New("any")), "Retry errors as default")
+}
+
+func TestClientDo_RetryPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(DummyHandler(http.StatusConflict, errResp))
+	defer server.Close()
+
+	var attempts []int
+	p := web.RetryPolicyFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		attempts = append(attempts, attempt)
+		return err == nil && resp.StatusCode == http.StatusConflict && req.URL.Path == "/conflict"
+	})
+	cl := web.NewClient(web.WithRetryPolicy(p), web.WithBackoff(web.Backoff{BaseSleep: 1, MaxSleep: 2}))
+
+	n, status, _, err := cl.Do(newGet(t, server.URL+"/conflict"), 3)
+	a.True(gaveUpOnStatus(err), "Out of tries on 409")
+	a.Equal(3, n, "Retry 409 for the endpoint")
+	a.Equal(http.StatusConflict, status, "Got 409")
+	a.Equal([]int{1, 2, 3}, attempts, "Policy sees every attempt")
+
+	n, _, _, _ = cl.Do(newGet(t, server.URL+"/other"), 3)
+	a.Equal(1, n, "Not retried for other endpoints")
+}
+
+type timeoutErr struct{}
+
+func (*timeoutErr) Error() string   { return "timeout" }
+func (*timeoutErr) Timeout() bool   { return true }
+func (*timeoutErr) Temporary() bool { return true }
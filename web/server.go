@@ -1,13 +1,11 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"os"
 )
 
 // QuickResponse writes standard HTTP code & text.
@@ -80,25 +78,8 @@ func GetLocalIP() string {
 	return ""
 }
 
-// DownloadFile downloads a file from the url.
+// DownloadFile downloads a file from the url,
+// resuming the part left by an interrupted one (see Downloader).
 func DownloadFile(url, file string) error {
-	out, err := os.Create(file)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return NewDownloader(nil, 0).Download(context.Background(), url, file)
 }
@@ -1,7 +1,14 @@
 package web_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ShevaXu/golang/web"
 )
@@ -11,7 +18,23 @@ func TestGetLocalIP(t *testing.T) {
 }
 
 func TestDownloadFile(t *testing.T) {
-	if err := web.DownloadFile("http://www.baidu.com", "/tmp/test-download"); err != nil {
+	content := bytes.Repeat([]byte("download"), 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test-download")
+
+	if err := web.DownloadFile(server.URL, file); err != nil {
 		t.Error(err.Error())
 	}
+	if got, _ := ioutil.ReadFile(file); !bytes.Equal(got, content) {
+		t.Error("content mismatch")
+	}
 }